@@ -0,0 +1,87 @@
+package purger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DeleteSeriesHandler implements the tenant-scoped delete-series API:
+//
+//	POST   /api/prom/admin/tsdb/delete_series?match[]=...&start=...&end=...
+//	GET    /api/prom/admin/tsdb/delete_series
+//	DELETE /api/prom/admin/tsdb/delete_series?request_id=...
+//
+// Every method requires X-Scope-OrgID, enforced the same way getOrgConfig
+// does for the ruler's configs API: a tenant can only ever see or cancel
+// their own requests. POST currently returns 501: see createDeleteRequest.
+func (p *Purger) DeleteSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-Scope-OrgID")
+	if userID == "" {
+		http.Error(w, "missing X-Scope-OrgID", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		p.createDeleteRequest(w, r, userID)
+	case http.MethodDelete:
+		p.cancelDeleteRequest(w, r, userID)
+	case http.MethodGet:
+		p.listDeleteRequests(w, r, userID)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createDeleteRequest is disabled: Purger.process can plan a delete (find
+// the affected chunks) but, lacking a chunk encoder/index-writer in this
+// tree, can't rewrite or remove them, so nothing ever finishes a request.
+// Accepting requests here would queue work the worker can never complete
+// rather than lying about either success or progress, so the POST is
+// rejected outright until chunk rewriting is implemented.
+func (p *Purger) createDeleteRequest(w http.ResponseWriter, r *http.Request, userID string) {
+	http.Error(w, "delete-series is not implemented yet", http.StatusNotImplemented)
+}
+
+func (p *Purger) cancelDeleteRequest(w http.ResponseWriter, r *http.Request, userID string) {
+	requestID := r.URL.Query().Get("request_id")
+	if requestID == "" {
+		http.Error(w, "must pass request_id", http.StatusBadRequest)
+		return
+	}
+
+	req, err := p.store.get(userID, requestID)
+	if err != nil {
+		http.Error(w, "request not found", http.StatusNotFound)
+		return
+	}
+
+	if req.Status != StatusPending {
+		http.Error(w, fmt.Sprintf("request is %s, can no longer be cancelled", req.Status), http.StatusConflict)
+		return
+	}
+	if time.Since(req.CreatedAt.Time()) >= p.cfg.cancellationPeriod() {
+		http.Error(w, "cancellation period has passed", http.StatusConflict)
+		return
+	}
+
+	req.Status = StatusCancelled
+	if err := p.store.put(req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Purger) listDeleteRequests(w http.ResponseWriter, r *http.Request, userID string) {
+	requests, err := p.store.list(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
@@ -0,0 +1,192 @@
+// Package purger implements the tenant-scoped delete-series API (list and
+// cancel requests; creating one is rejected with 501) and a background
+// worker that finds the chunks a request affects. Chunks are not rewritten
+// or removed, so no request reaches StatusProcessed.
+package purger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage/metric"
+	"golang.org/x/net/context"
+
+	"github.com/weaveworks/cortex/chunk"
+)
+
+// DefaultCancellationPeriod is how long a request sits as Pending, and
+// cancellable, before the purger starts acting on it.
+const DefaultCancellationPeriod = 24 * time.Hour
+
+// Status is the lifecycle state of a DeletionRequest.
+type Status string
+
+// Values for Status.
+const (
+	StatusPending      Status = "pending"
+	StatusBuildingPlan Status = "building_plan"
+	StatusDeleting     Status = "deleting"
+	StatusProcessed    Status = "processed"
+	StatusCancelled    Status = "cancelled"
+)
+
+// DeletionRequest is a tenant's request to delete every sample matching
+// Matchers in [StartTime, EndTime].
+type DeletionRequest struct {
+	RequestID string     `json:"request_id"`
+	UserID    string     `json:"user_id"`
+	Matchers  []string   `json:"matchers"`
+	StartTime model.Time `json:"start_time"`
+	EndTime   model.Time `json:"end_time"`
+	CreatedAt model.Time `json:"created_at"`
+	Status    Status     `json:"status"`
+}
+
+// Config configures a Purger.
+type Config struct {
+	ObjectStore        ObjectStore
+	ChunkStore         chunk.Store
+	CancellationPeriod time.Duration
+	PollInterval       time.Duration
+}
+
+func (cfg Config) cancellationPeriod() time.Duration {
+	if cfg.CancellationPeriod > 0 {
+		return cfg.CancellationPeriod
+	}
+	return DefaultCancellationPeriod
+}
+
+func (cfg Config) pollInterval() time.Duration {
+	if cfg.PollInterval > 0 {
+		return cfg.PollInterval
+	}
+	return time.Hour
+}
+
+// Purger owns the delete-series API's background half: it watches for
+// requests whose cancellation window has passed and runs process on them.
+type Purger struct {
+	cfg   Config
+	store *requestStore
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewPurger makes a new Purger and starts its background worker.
+func NewPurger(cfg Config) *Purger {
+	p := &Purger{
+		cfg:   cfg,
+		store: newRequestStore(cfg.ObjectStore),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+// Stop shuts down the background worker.
+func (p *Purger) Stop() {
+	close(p.quit)
+	<-p.done
+}
+
+func (p *Purger) loop() {
+	defer close(p.done)
+	tick := time.NewTicker(p.cfg.pollInterval())
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			p.runOnce()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// runOnce processes every request that is Pending and past its
+// cancellation period, or that is already partway through processing
+// (eg. the purger restarted mid-plan).
+func (p *Purger) runOnce() {
+	requests, err := p.store.list("")
+	if err != nil {
+		log.Warnf("purger: error listing delete requests: %v", err)
+		return
+	}
+
+	for _, req := range requests {
+		switch req.Status {
+		case StatusPending:
+			if time.Since(req.CreatedAt.Time()) < p.cfg.cancellationPeriod() {
+				continue
+			}
+		case StatusBuildingPlan, StatusDeleting:
+			// Resume a request a previous purger instance left
+			// mid-flight.
+		default:
+			continue
+		}
+
+		if err := p.process(req); err != nil {
+			log.Warnf("purger: error processing delete request %s: %v", req.RequestID, err)
+		}
+	}
+}
+
+// process moves req to StatusBuildingPlan, finds the chunks of series
+// matching Matchers within [StartTime, EndTime], and moves req to
+// StatusDeleting. It does not rewrite, split or remove those chunks, and
+// always returns an error once the chunks are found.
+func (p *Purger) process(req DeletionRequest) error {
+	matchers, err := parseMatchers(req.Matchers)
+	if err != nil {
+		return err
+	}
+
+	req.Status = StatusBuildingPlan
+	if err := p.store.put(req); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	affected, err := p.cfg.ChunkStore.Get(ctx, req.UserID, req.StartTime, req.EndTime, matchers...)
+	if err != nil {
+		return err
+	}
+
+	req.Status = StatusDeleting
+	if err := p.store.put(req); err != nil {
+		return err
+	}
+
+	// TODO: for each affected chunk, rewrite it without the samples in
+	// [req.StartTime, req.EndTime] (splitting it in two if the deleted
+	// range falls in the middle), write the replacement chunks and index
+	// entries, and schedule the original chunks for removal once nothing
+	// references them. It needs the chunk encoder/index-writer this
+	// package doesn't have access to yet, so the request is left in
+	// StatusDeleting - not StatusProcessed, which would be a lie - and
+	// runOnce will keep picking it back up until that lands.
+	_ = affected
+	return fmt.Errorf("rewriting chunks for delete request %s is not implemented yet", req.RequestID)
+}
+
+// parseMatchers parses each of raw as a PromQL metric selector (the form
+// of the API's match[] parameter) and flattens the result into a
+// single matcher list, the same shape chunk.Store.Get expects.
+func parseMatchers(raw []string) ([]*metric.LabelMatcher, error) {
+	var out []*metric.LabelMatcher
+	for _, s := range raw {
+		matchers, err := promql.ParseMetricSelector(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: %v", s, err)
+		}
+		out = append(out, matchers...)
+	}
+	return out, nil
+}
@@ -0,0 +1,155 @@
+package purger
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/storage/metric"
+
+	"github.com/weaveworks/cortex/chunk"
+)
+
+type fakeObjectStore struct {
+	objects map[string][]byte
+}
+
+func newFakeObjectStore() *fakeObjectStore {
+	return &fakeObjectStore{objects: map[string][]byte{}}
+}
+
+func (f *fakeObjectStore) PutObject(ctx context.Context, key string, data []byte) error {
+	f.objects[key] = data
+	return nil
+}
+
+func (f *fakeObjectStore) GetObject(ctx context.Context, key string) ([]byte, error) {
+	data, ok := f.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("not found: %s", key)
+	}
+	return data, nil
+}
+
+func (f *fakeObjectStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+type fakeChunkStore struct {
+	gotMatchers []*metric.LabelMatcher
+}
+
+func (f *fakeChunkStore) Put(ctx context.Context, chunks []chunk.Chunk) error { return nil }
+
+func (f *fakeChunkStore) Get(ctx context.Context, userID string, from, through model.Time, matchers ...*metric.LabelMatcher) ([]chunk.Chunk, error) {
+	f.gotMatchers = matchers
+	return nil, nil
+}
+
+func TestRequestStoreRoundTrip(t *testing.T) {
+	store := newRequestStore(newFakeObjectStore())
+
+	req := DeletionRequest{
+		RequestID: "abc",
+		UserID:    "user1",
+		Matchers:  []string{`{__name__="up"}`},
+		Status:    StatusPending,
+	}
+	if err := store.put(req); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := store.get("user1", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have.RequestID != req.RequestID || have.Status != req.Status {
+		t.Fatalf("bad round trip: %+v", have)
+	}
+
+	requests, err := store.list("user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+}
+
+func TestParseMatchers(t *testing.T) {
+	matchers, err := parseMatchers([]string{`{__name__="up",job="foo"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matchers) != 2 {
+		t.Fatalf("expected 2 matchers, got %d", len(matchers))
+	}
+}
+
+func TestParseMatchersInvalid(t *testing.T) {
+	if _, err := parseMatchers([]string{`{`}); err == nil {
+		t.Fatalf("expected an error for an invalid matcher")
+	}
+}
+
+func TestProcessAppliesMatchersAndDoesNotClaimSuccess(t *testing.T) {
+	cs := &fakeChunkStore{}
+	p := &Purger{
+		cfg:   Config{ChunkStore: cs},
+		store: newRequestStore(newFakeObjectStore()),
+	}
+
+	req := DeletionRequest{
+		RequestID: "abc",
+		UserID:    "user1",
+		Matchers:  []string{`{__name__="up"}`},
+	}
+	if err := p.process(req); err == nil {
+		t.Fatalf("expected process to report the rewrite step is unimplemented")
+	}
+	if len(cs.gotMatchers) != 1 {
+		t.Fatalf("expected the parsed matchers to reach ChunkStore.Get, got %v", cs.gotMatchers)
+	}
+
+	stored, err := p.store.get(req.UserID, req.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status == StatusProcessed {
+		t.Fatalf("process must not mark the request Processed before chunks are actually rewritten")
+	}
+}
+
+func TestCreateDeleteRequestIsNotImplemented(t *testing.T) {
+	p := &Purger{store: newRequestStore(newFakeObjectStore())}
+
+	req := httptest.NewRequest(http.MethodPost, `/api/prom/admin/tsdb/delete_series?match[]={__name__="up"}&start=0&end=1`, nil)
+	req.Header.Set("X-Scope-OrgID", "user1")
+	rec := httptest.NewRecorder()
+
+	p.DeleteSeriesHandler(rec, req)
+
+	if rec.Code != http.StatusNotImplemented {
+		t.Fatalf("expected 501, got %d", rec.Code)
+	}
+	requests, err := p.store.list("user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("expected no request to be queued, got %d", len(requests))
+	}
+}
@@ -0,0 +1,84 @@
+package purger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// ObjectStore is the minimal object storage interface the purger needs to
+// persist deletion requests - satisfied by the same backends (S3, GCS,
+// ...) chunk data itself is stored in.
+type ObjectStore interface {
+	PutObject(ctx context.Context, key string, data []byte) error
+	GetObject(ctx context.Context, key string) ([]byte, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// requestStore persists DeletionRequests as one object per request, keyed
+// by tenant so a tenant can only ever see their own.
+type requestStore struct {
+	objects ObjectStore
+}
+
+func newRequestStore(objects ObjectStore) *requestStore {
+	return &requestStore{objects: objects}
+}
+
+func requestKey(userID, requestID string) string {
+	return fmt.Sprintf("delete_requests/%s/%s.json", userID, requestID)
+}
+
+func (s *requestStore) put(req DeletionRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return s.objects.PutObject(context.Background(), requestKey(req.UserID, req.RequestID), data)
+}
+
+func (s *requestStore) get(userID, requestID string) (DeletionRequest, error) {
+	data, err := s.objects.GetObject(context.Background(), requestKey(userID, requestID))
+	if err != nil {
+		return DeletionRequest{}, err
+	}
+	var req DeletionRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return DeletionRequest{}, err
+	}
+	return req, nil
+}
+
+// list returns every request for userID, or for every tenant if userID is
+// empty - used by the purger's background worker, which must consider all
+// tenants' requests.
+func (s *requestStore) list(userID string) ([]DeletionRequest, error) {
+	prefix := "delete_requests/"
+	if userID != "" {
+		prefix = fmt.Sprintf("delete_requests/%s/", userID)
+	}
+
+	keys, err := s.objects.List(context.Background(), prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]DeletionRequest, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		data, err := s.objects.GetObject(context.Background(), key)
+		if err != nil {
+			return nil, err
+		}
+		var req DeletionRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
@@ -0,0 +1,25 @@
+// Package chunk stores and retrieves time series data as chunks of
+// samples, indexed for lookup by label matchers over a time range.
+package chunk
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/storage/metric"
+)
+
+// Chunk is a contiguous, encoded run of a single series' samples.
+type Chunk struct {
+	UserID  string
+	Metric  model.Metric
+	From    model.Time
+	Through model.Time
+	Data    []byte
+}
+
+// Store is the interface the rest of Cortex uses to put and get chunks.
+type Store interface {
+	Put(ctx context.Context, chunks []Chunk) error
+	Get(ctx context.Context, userID string, from, through model.Time, matchers ...*metric.LabelMatcher) ([]Chunk, error)
+}
@@ -0,0 +1,159 @@
+// Package distributor is the write path entry point: it deduplicates
+// HA-paired replicas and resolves which ingesters own each incoming
+// series. It does not forward the samples to those ingesters.
+package distributor
+
+import (
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/prometheus/storage/remote"
+
+	"github.com/weaveworks/cortex/ring"
+	"github.com/weaveworks/cortex/user"
+)
+
+// ringKey is where the ingester ring's Desc lives in the KV store.
+const ringKey = "ring/ingester"
+
+// DefaultReplicationFactor is used if Config.ReplicationFactor isn't set.
+const DefaultReplicationFactor = 3
+
+// Config is the configuration for a Distributor.
+type Config struct {
+	HATrackerConfig HATrackerConfig
+
+	// RingKVClient is the KV client the ingester ring's Desc is read
+	// from - the same ring ingesters join via ring.Lifecycler.
+	RingKVClient ring.KVClient
+	// ReplicationFactor and MaxUnavailable configure the quorum used to
+	// pick owning ingesters for each series; see ring.ReplicationStrategy.
+	ReplicationFactor int
+	MaxUnavailable    int
+	HeartbeatTimeout  time.Duration
+}
+
+func (cfg Config) replicationFactor() int {
+	if cfg.ReplicationFactor > 0 {
+		return cfg.ReplicationFactor
+	}
+	return DefaultReplicationFactor
+}
+
+// Distributor deduplicates HA-paired Prometheus replicas and checks that a
+// write quorum of owning ingesters exists for each series; it does not send
+// the samples to them.
+type Distributor struct {
+	cfg       Config
+	haTracker *HATracker
+	ring      ring.ReadRing
+}
+
+// New constructs a new Distributor.
+func New(cfg Config) (*Distributor, error) {
+	strategy := ring.ReplicationStrategy{
+		ReplicationFactor: cfg.replicationFactor(),
+		MaxUnavailable:    cfg.MaxUnavailable,
+		HeartbeatTimeout:  cfg.HeartbeatTimeout,
+	}
+	return &Distributor{
+		cfg:       cfg,
+		haTracker: NewHATracker(cfg.HATrackerConfig),
+		ring:      ring.New(cfg.RingKVClient, ringKey, strategy),
+	}, nil
+}
+
+// WriteResponse is returned by Push on success.
+type WriteResponse struct{}
+
+// Push validates req, deduplicates HA-paired replicas, and resolves the
+// quorum of ingesters that own each series against the ring.
+func (d *Distributor) Push(ctx context.Context, req *remote.WriteRequest) (*WriteResponse, error) {
+	clusterLabel, replicaLabel := d.cfg.HATrackerConfig.clusterLabel(), d.cfg.HATrackerConfig.replicaLabel()
+	cluster, replica, ok := haLabels(req, clusterLabel, replicaLabel)
+	if ok {
+		if err := d.haTracker.CheckReplica(ctx, cluster, replica); err != nil {
+			return nil, err
+		}
+		req = stripHALabels(req, clusterLabel, replicaLabel)
+	}
+
+	userID, err := user.ExtractID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO: this package has no ingester RPC client yet, so resolving the
+	// owning ingesters below is as far as a write gets - nothing actually
+	// forwards the samples to them. Wiring that in needs a client this
+	// package doesn't have access to yet; in the meantime this at least
+	// exercises the real quorum (ReplicationFactor/MaxUnavailable), and
+	// returns ring.ErrInsufficientReplicas rather than silently
+	// succeeding, instead of today's naive membership check.
+	for _, ts := range req.Timeseries {
+		if _, err := d.ring.Get(tokenFor(userID, ts.Labels), ring.Write); err != nil {
+			return nil, err
+		}
+	}
+
+	return &WriteResponse{}, nil
+}
+
+// tokenFor hashes a series (scoped by tenant) onto the ring's token space.
+// labels are sorted first so the token doesn't depend on the wire order
+// Prometheus happened to send them in.
+func tokenFor(userID string, labels []*remote.LabelPair) uint32 {
+	sorted := make([]*remote.LabelPair, len(labels))
+	copy(sorted, labels)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	for _, pair := range sorted {
+		h.Write([]byte{0})
+		h.Write([]byte(pair.Name))
+		h.Write([]byte{0})
+		h.Write([]byte(pair.Value))
+	}
+	return h.Sum32()
+}
+
+// haLabels extracts the values of clusterLabel/replicaLabel from the
+// external labels Prometheus attaches to every series in req. All series
+// in a single remote-write request share the same external labels, so it
+// is enough to look at the first one.
+func haLabels(req *remote.WriteRequest, clusterLabel, replicaLabel string) (cluster, replica string, ok bool) {
+	if len(req.Timeseries) == 0 {
+		return "", "", false
+	}
+	var haveCluster, haveReplica bool
+	for _, pair := range req.Timeseries[0].Labels {
+		switch pair.Name {
+		case clusterLabel:
+			cluster, haveCluster = pair.Value, true
+		case replicaLabel:
+			replica, haveReplica = pair.Value, true
+		}
+	}
+	return cluster, replica, haveCluster && haveReplica
+}
+
+// stripHALabels removes clusterLabel/replicaLabel from every series in
+// req before it's forwarded to ingesters - they're metadata about the
+// write path, not part of the time series identity.
+func stripHALabels(req *remote.WriteRequest, clusterLabel, replicaLabel string) *remote.WriteRequest {
+	for _, ts := range req.Timeseries {
+		kept := ts.Labels[:0]
+		for _, pair := range ts.Labels {
+			if pair.Name == clusterLabel || pair.Name == replicaLabel {
+				continue
+			}
+			kept = append(kept, pair)
+		}
+		ts.Labels = kept
+	}
+	return req
+}
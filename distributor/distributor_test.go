@@ -0,0 +1,80 @@
+package distributor
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/prometheus/storage/remote"
+
+	"github.com/weaveworks/cortex/ring"
+	"github.com/weaveworks/cortex/user"
+)
+
+// fakeRingKV is a minimal ring.KVClient backed by a fixed *ring.Desc - it
+// is distinct from fakeKV in ha_tracker_test.go, which only implements
+// this package's own (narrower) KVClient interface for the HA tracker.
+type fakeRingKV struct {
+	value interface{}
+}
+
+func (kv *fakeRingKV) CAS(key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	out, _, err := f(kv.value)
+	if err != nil {
+		return err
+	}
+	kv.value = out
+	return nil
+}
+
+func (kv *fakeRingKV) Get(key string) (interface{}, error) { return kv.value, nil }
+
+func (kv *fakeRingKV) WatchKey(key string, done <-chan struct{}, f func(interface{}) bool) {}
+
+func writeRequest(labels ...*remote.LabelPair) *remote.WriteRequest {
+	return &remote.WriteRequest{
+		Timeseries: []*remote.TimeSeries{
+			{Labels: labels, Samples: []*remote.Sample{{Value: 1, TimestampMs: 1000}}},
+		},
+	}
+}
+
+func testDesc(state ring.IngesterState) *ring.Desc {
+	desc := &ring.Desc{Ingesters: map[string]ring.IngesterDesc{}}
+	for i, id := range []string{"a", "b", "c"} {
+		desc.Ingesters[id] = ring.IngesterDesc{Hostname: id, GRPCHostname: id, State: state, Heartbeat: time.Now()}
+		desc.Tokens = append(desc.Tokens, ring.TokenDesc{Token: uint32(i * 1000), Ingester: id})
+	}
+	return desc
+}
+
+func TestPushSucceedsWhenQuorumIsMet(t *testing.T) {
+	kv := &fakeRingKV{value: testDesc(ring.Active)}
+
+	d, err := New(Config{RingKVClient: kv, ReplicationFactor: 3, HeartbeatTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := user.WithID(context.Background(), "user1")
+	req := writeRequest(&remote.LabelPair{Name: "__name__", Value: "up"})
+	if _, err := d.Push(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPushFailsWhenQuorumCannotBeMet(t *testing.T) {
+	kv := &fakeRingKV{value: testDesc(ring.Unhealthy)}
+
+	d, err := New(Config{RingKVClient: kv, ReplicationFactor: 3, HeartbeatTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := user.WithID(context.Background(), "user1")
+	req := writeRequest(&remote.LabelPair{Name: "__name__", Value: "up"})
+	if _, err := d.Push(ctx, req); err == nil {
+		t.Fatalf("expected Push to fail quorum when every ingester is unhealthy")
+	}
+}
@@ -0,0 +1,154 @@
+package distributor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+
+	"github.com/weaveworks/cortex/user"
+)
+
+const (
+	defaultClusterLabel  = "cluster"
+	defaultReplicaLabel  = "__replica__"
+	defaultFailoverAfter = 30 * time.Second
+)
+
+// KVClient is the key/value interface the HATracker needs from its
+// backing store (Consul or etcd): an optimistic read-modify-write. It is
+// just the CAS method of ring.KVClient, so any ring.KVClient satisfies it.
+type KVClient interface {
+	CAS(key string, f func(in interface{}) (out interface{}, retry bool, err error)) error
+}
+
+// HATrackerConfig configures an HATracker.
+type HATrackerConfig struct {
+	KVClient KVClient
+
+	// ClusterLabel and ReplicaLabel name the external labels Prometheus
+	// attaches identifying which HA cluster/replica a sample came from.
+	ClusterLabel string
+	ReplicaLabel string
+
+	// FailoverTimeout is how long the elected replica can go silent
+	// before another replica is allowed to take over election.
+	FailoverTimeout time.Duration
+}
+
+func (cfg HATrackerConfig) clusterLabel() string {
+	if cfg.ClusterLabel != "" {
+		return cfg.ClusterLabel
+	}
+	return defaultClusterLabel
+}
+
+func (cfg HATrackerConfig) replicaLabel() string {
+	if cfg.ReplicaLabel != "" {
+		return cfg.ReplicaLabel
+	}
+	return defaultReplicaLabel
+}
+
+func (cfg HATrackerConfig) failoverTimeout() time.Duration {
+	if cfg.FailoverTimeout != 0 {
+		return cfg.FailoverTimeout
+	}
+	return defaultFailoverAfter
+}
+
+// electedReplica is what's stored in the KV store per (userID, cluster).
+type electedReplica struct {
+	Replica   string    `json:"replica"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// replicasNotMatchError is returned by CheckReplica when replica isn't the
+// one currently elected for cluster. Distributor.Push returns it to its
+// caller as is; nothing in this package maps it to an HTTP status.
+type replicasNotMatchError struct {
+	replica, elected string
+}
+
+func (e *replicasNotMatchError) Error() string {
+	return fmt.Sprintf("replicas did not match, expected %s, got %s", e.elected, e.replica)
+}
+
+// electionsTotal and failoversTotal are registered once, at package init,
+// rather than per HATracker - NewHATracker can be called more than once in
+// a process (eg. in tests, or a future multi-tenant-scoped tracker), and
+// prometheus.MustRegister panics on the second registration of the same
+// metric.
+var (
+	electionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "distributor_ha_tracker_elections_total",
+		Help:      "Number of times a HA replica was elected for a tenant's cluster.",
+	}, []string{"user"})
+	failoversTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "distributor_ha_tracker_failovers_total",
+		Help:      "Number of times HA replica election failed over to a different replica.",
+	}, []string{"user"})
+)
+
+func init() {
+	prometheus.MustRegister(electionsTotal, failoversTotal)
+}
+
+// HATracker elects, per tenant and cluster, a single accepted replica out
+// of an HA pair of Prometheus servers remote-writing the same samples, so
+// the distributor only ingests one copy of each.
+type HATracker struct {
+	cfg HATrackerConfig
+}
+
+// NewHATracker makes a new HATracker.
+func NewHATracker(cfg HATrackerConfig) *HATracker {
+	return &HATracker{cfg: cfg}
+}
+
+// CheckReplica elects replica as the accepted replica for (tenant,
+// cluster) if none is elected yet, the election has gone stale, or
+// replica is already the elected one. It returns replicasNotMatchError if
+// some other replica is currently elected and still within its lease.
+func (h *HATracker) CheckReplica(ctx context.Context, cluster, replica string) error {
+	userID, err := user.ExtractID(ctx)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("ha/%s/%s", userID, cluster)
+
+	var elected electedReplica
+	err = h.cfg.KVClient.CAS(key, func(in interface{}) (interface{}, bool, error) {
+		now := time.Now()
+		current, ok := in.(*electedReplica)
+
+		switch {
+		case !ok || current == nil:
+			electionsTotal.WithLabelValues(userID).Inc()
+		case current.Replica == replica:
+			// Still the same replica: just refresh its lease.
+		case now.Sub(current.Timestamp) > h.cfg.failoverTimeout():
+			failoversTotal.WithLabelValues(userID).Inc()
+		default:
+			// Another replica is still within its lease: don't write,
+			// just report what's currently elected.
+			elected = *current
+			return current, false, nil
+		}
+
+		elected = electedReplica{Replica: replica, Timestamp: now}
+		return &elected, true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if elected.Replica != replica {
+		return &replicasNotMatchError{replica: replica, elected: elected.Replica}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package distributor
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/weaveworks/cortex/user"
+)
+
+type fakeKV struct {
+	value interface{}
+}
+
+func (kv *fakeKV) CAS(key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	out, _, err := f(kv.value)
+	if err != nil {
+		return err
+	}
+	kv.value = out
+	return nil
+}
+
+func TestNewHATrackerCanBeCalledMoreThanOnce(t *testing.T) {
+	// Registering electionsTotal/failoversTotal a second time used to
+	// panic via prometheus.MustRegister; NewHATracker must be safe to
+	// call repeatedly (eg. once per test in this very file).
+	NewHATracker(HATrackerConfig{KVClient: &fakeKV{}})
+	NewHATracker(HATrackerConfig{KVClient: &fakeKV{}})
+}
+
+func TestCheckReplicaElectsFirstReplica(t *testing.T) {
+	tracker := NewHATracker(HATrackerConfig{KVClient: &fakeKV{}})
+	ctx := user.WithID(context.Background(), "user1")
+
+	if err := tracker.CheckReplica(ctx, "cluster1", "replica1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.CheckReplica(ctx, "cluster1", "replica1"); err != nil {
+		t.Fatalf("the elected replica should always pass CheckReplica: %v", err)
+	}
+}
+
+func TestCheckReplicaRejectsOtherReplicaWithinLease(t *testing.T) {
+	tracker := NewHATracker(HATrackerConfig{KVClient: &fakeKV{}, FailoverTimeout: time.Hour})
+	ctx := user.WithID(context.Background(), "user1")
+
+	if err := tracker.CheckReplica(ctx, "cluster1", "replica1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.CheckReplica(ctx, "cluster1", "replica2"); err == nil {
+		t.Fatalf("expected a non-elected replica to be rejected while the lease is live")
+	}
+}
@@ -0,0 +1,243 @@
+// Package ingester is the write path's last stop: it accepts samples
+// pushed by the distributor, appends each one to a write-ahead log before
+// acknowledging it, and folds it into the in-memory series set chunks are
+// eventually built from.
+package ingester
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/storage/remote"
+
+	"github.com/weaveworks/cortex/ingester/wal"
+	"github.com/weaveworks/cortex/ring"
+	"github.com/weaveworks/cortex/user"
+)
+
+// DefaultCheckpointInterval is used if Config.CheckpointInterval isn't set.
+const DefaultCheckpointInterval = 5 * time.Minute
+
+// Config configures an Ingester.
+type Config struct {
+	WAL        wal.Config
+	Lifecycler ring.LifecyclerConfig
+
+	// CheckpointInterval is how often the in-memory series set is
+	// checkpointed and the WAL segments it makes redundant truncated.
+	CheckpointInterval time.Duration
+}
+
+func (cfg Config) checkpointInterval() time.Duration {
+	if cfg.CheckpointInterval > 0 {
+		return cfg.CheckpointInterval
+	}
+	return DefaultCheckpointInterval
+}
+
+// series is the in-memory state WAL replay reconstructs and Push appends
+// to: a series' labels and its most recent sample. Building this up into
+// chunks for long-term storage is out of scope here.
+type series struct {
+	userID  string
+	labels  model.Metric
+	lastTS  model.Time
+	lastVal model.SampleValue
+}
+
+// Ingester owns one shard of the write path's in-memory series set.
+type Ingester struct {
+	cfg        Config
+	wal        *wal.WAL
+	lifecycler *ring.Lifecycler
+
+	mtx    sync.Mutex
+	series map[model.Fingerprint]*series
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// WriteResponse is returned by Push on success.
+type WriteResponse struct{}
+
+// New opens cfg.WAL, replays it (and any checkpoint) to reconstruct the
+// in-memory series set, and joins cfg.Lifecycler's ring. The ring
+// transitions are driven explicitly rather than left to the lifecycler's
+// own timers: this ingester has no business serving reads or writes until
+// replay has actually finished, which only it can know.
+func New(cfg Config) (*Ingester, error) {
+	w, err := wal.Open(cfg.WAL)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Lifecycler.ManualTransitions = true
+	lifecycler, err := ring.NewLifecycler(cfg.Lifecycler)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Ingester{
+		cfg:        cfg,
+		wal:        w,
+		lifecycler: lifecycler,
+		series:     map[model.Fingerprint]*series{},
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	// Joining tells the rest of the ring not to route reads or writes
+	// here while replay below reconstructs our series set.
+	if err := lifecycler.ChangeState(ring.Joining); err != nil {
+		return nil, err
+	}
+
+	if err := i.replay(); err != nil {
+		return nil, err
+	}
+
+	if err := lifecycler.ChangeState(ring.Active); err != nil {
+		return nil, err
+	}
+
+	go i.checkpointLoop()
+
+	return i, nil
+}
+
+// checkpointLoop periodically checkpoints the in-memory series set and
+// truncates the WAL segments it makes redundant, so segments don't
+// accumulate forever.
+func (i *Ingester) checkpointLoop() {
+	defer close(i.done)
+	tick := time.NewTicker(i.cfg.checkpointInterval())
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := i.checkpoint(); err != nil {
+				log.Warnf("ingester: error checkpointing WAL: %v", err)
+			}
+		case <-i.quit:
+			return
+		}
+	}
+}
+
+// checkpoint snapshots the in-memory series set and truncates the WAL
+// segments it makes redundant. snapshot runs with the WAL locked, so it
+// can't race a concurrent Push's Log call into truncating a segment that
+// holds a sample not yet reflected in the snapshot.
+func (i *Ingester) checkpoint() error {
+	return wal.Checkpoint(i.wal, i.snapshot)
+}
+
+// snapshot captures every series' last sample as of now.
+func (i *Ingester) snapshot() []wal.SeriesSnapshot {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	snapshots := make([]wal.SeriesSnapshot, 0, len(i.series))
+	for fp, s := range i.series {
+		snapshots = append(snapshots, wal.SeriesSnapshot{
+			UserID:      s.userID,
+			Fingerprint: fp,
+			Labels:      s.labels,
+			Timestamp:   s.lastTS,
+			Value:       s.lastVal,
+		})
+	}
+	return snapshots
+}
+
+// replay loads the last checkpoint, if any, then replays every WAL record
+// since it into the in-memory series set.
+func (i *Ingester) replay() error {
+	checkpoint, err := wal.LoadCheckpoint(i.cfg.WAL.Dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range checkpoint {
+		i.series[s.Fingerprint] = &series{userID: s.UserID, labels: s.Labels, lastTS: s.Timestamp, lastVal: s.Value}
+	}
+
+	return wal.Replay(i.cfg.WAL.Dir, wal.CheckpointedAt(checkpoint), i.apply)
+}
+
+// apply folds a single WAL record into the in-memory series set, the same
+// way Push does for a freshly-written one.
+func (i *Ingester) apply(rec *wal.Record) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	s, ok := i.series[rec.Fingerprint]
+	if !ok {
+		s = &series{userID: rec.UserID}
+		i.series[rec.Fingerprint] = s
+	}
+	if rec.Labels != nil {
+		s.labels = rec.Labels
+	}
+	s.lastTS = rec.Timestamp
+	s.lastVal = rec.Value
+}
+
+// Push logs every sample in req to the WAL before folding it into the
+// in-memory series set, so a crash between the two never loses an
+// acknowledged write.
+func (i *Ingester) Push(ctx context.Context, req *remote.WriteRequest) (*WriteResponse, error) {
+	userID, err := user.ExtractID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ts := range req.Timeseries {
+		labels := make(model.Metric, len(ts.Labels))
+		for _, pair := range ts.Labels {
+			labels[model.LabelName(pair.Name)] = model.LabelValue(pair.Value)
+		}
+		fp := labels.Fingerprint()
+
+		for _, sample := range ts.Samples {
+			rec := &wal.Record{
+				UserID:      userID,
+				Fingerprint: fp,
+				Timestamp:   model.Time(sample.TimestampMs),
+				Value:       model.SampleValue(sample.Value),
+			}
+			i.mtx.Lock()
+			_, seen := i.series[fp]
+			i.mtx.Unlock()
+			if !seen {
+				rec.Labels = labels
+			}
+			if err := i.wal.Log(rec); err != nil {
+				return nil, err
+			}
+			i.apply(rec)
+		}
+	}
+	return &WriteResponse{}, nil
+}
+
+// Shutdown stops checkpointLoop, takes a final checkpoint so segments
+// written since the last periodic one aren't replayed from scratch next
+// time, then flushes and closes the WAL and leaves the ring.
+func (i *Ingester) Shutdown() error {
+	close(i.quit)
+	<-i.done
+
+	if err := i.checkpoint(); err != nil {
+		return err
+	}
+
+	if err := i.lifecycler.Shutdown(); err != nil {
+		return err
+	}
+	return i.wal.Close()
+}
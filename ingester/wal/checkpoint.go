@@ -0,0 +1,123 @@
+package wal
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/common/model"
+)
+
+const checkpointFile = "checkpoint"
+
+// SeriesSnapshot is one series' state as of a Checkpoint: its last sample,
+// which is all Replay needs to resume from - everything before it has
+// already been accounted for.
+type SeriesSnapshot struct {
+	UserID      string
+	Fingerprint model.Fingerprint
+	Labels      model.Metric
+	Timestamp   model.Time
+	Value       model.SampleValue
+}
+
+// Checkpoint calls snapshot to capture every series' last sample, writes
+// it to dir/checkpoint, then truncates WAL segments that predate the
+// segment the WAL was writing to when the snapshot was taken. Segments
+// before that are now redundant: everything in them is already reflected
+// in the checkpoint.
+//
+// The WAL is locked for the whole call to snapshot, not just the segment
+// read: Log is built as "append to the WAL, then fold into memory", so if
+// the segment were read after snapshot instead, a Log call that lands in
+// between - appending a sample not yet in the snapshot, then rotating to
+// a new segment - would have its only copy truncated away by the time
+// it's folded in. Locking across both makes the pair atomic with respect
+// to concurrent Log calls: any Log that's blocked on the mutex hasn't
+// reached the WAL yet, so it can't have been truncated.
+func Checkpoint(w *WAL, snapshot func() []SeriesSnapshot) error {
+	w.mtx.Lock()
+	segment := w.curSegment
+	series := snapshot()
+	w.mtx.Unlock()
+
+	tmp := filepath.Join(w.cfg.Dir, checkpointFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range series {
+		rec := &Record{
+			UserID:      s.UserID,
+			Fingerprint: s.Fingerprint,
+			Labels:      s.Labels,
+			Timestamp:   s.Timestamp,
+			Value:       s.Value,
+		}
+		if _, err := f.Write(rec.encode()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	final := filepath.Join(w.cfg.Dir, checkpointFile)
+	if err := os.Rename(tmp, final); err != nil {
+		return err
+	}
+
+	return w.TruncateBefore(segment)
+}
+
+// LoadCheckpoint reads back the most recent Checkpoint in dir, or returns
+// an empty snapshot (and no error) if there isn't one yet.
+func LoadCheckpoint(dir string) ([]SeriesSnapshot, error) {
+	f, err := os.Open(filepath.Join(dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var snapshots []SeriesSnapshot
+	for {
+		rec, err := readRecord(f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, SeriesSnapshot{
+			UserID:      rec.UserID,
+			Fingerprint: rec.Fingerprint,
+			Labels:      rec.Labels,
+			Timestamp:   rec.Timestamp,
+			Value:       rec.Value,
+		})
+	}
+	return snapshots, nil
+}
+
+// CheckpointedAt returns the latest timestamp covered by dir's checkpoint,
+// ie. the point Replay can safely skip records older than. If there is no
+// checkpoint yet, it returns 0 so Replay processes the whole WAL.
+func CheckpointedAt(snapshots []SeriesSnapshot) model.Time {
+	var max model.Time
+	for _, s := range snapshots {
+		if s.Timestamp > max {
+			max = s.Timestamp
+		}
+	}
+	return max
+}
@@ -0,0 +1,99 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestCheckpointTruncatesSegmentsItCovers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(Config{Dir: dir, SegmentSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// SegmentSize: 1 rotates to a new segment on every Log, so this leaves
+	// 4 segments on disk before the checkpoint: the empty one Open created,
+	// plus one per Log.
+	for i := 0; i < 3; i++ {
+		rec := &Record{Fingerprint: model.Fingerprint(1), Labels: model.Metric{"__name__": "up"}, Timestamp: model.Time(i)}
+		if err := w.Log(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segment := w.curSegment
+	snapshot := []SeriesSnapshot{
+		{Fingerprint: model.Fingerprint(1), Labels: model.Metric{"__name__": "up"}, Timestamp: model.Time(2)},
+	}
+	if err := Checkpoint(w, func() []SeriesSnapshot { return snapshot }); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 || segments[0] != segment {
+		t.Fatalf("expected only the current segment (%d) to survive, got %v", segment, segments)
+	}
+}
+
+func TestLoadCheckpointRoundTrips(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	want := []SeriesSnapshot{
+		{UserID: "user1", Fingerprint: model.Fingerprint(1), Labels: model.Metric{"__name__": "up"}, Timestamp: model.Time(5), Value: model.SampleValue(42)},
+	}
+	if err := Checkpoint(w, func() []SeriesSnapshot { return want }); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], want[0]) {
+		t.Fatalf("expected LoadCheckpoint to round-trip %+v, got %+v", want, got)
+	}
+
+	if at := CheckpointedAt(got); at != model.Time(5) {
+		t.Fatalf("expected CheckpointedAt to return 5, got %v", at)
+	}
+}
+
+func TestLoadCheckpointWithNoneYetWritten(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	got, err := LoadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Fatalf("expected no checkpoint yet to return nil, got %+v", got)
+	}
+}
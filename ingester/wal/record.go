@@ -0,0 +1,160 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/prometheus/common/model"
+)
+
+// Record is a single WAL entry: one sample accepted by the ingester's
+// Push, plus the series' labels the first time a fingerprint is seen (so
+// replay can reconstruct the series without consulting anything else).
+type Record struct {
+	UserID      string
+	Fingerprint model.Fingerprint
+	Labels      model.Metric // only set the first time Fingerprint is logged
+	Timestamp   model.Time
+	Value       model.SampleValue
+}
+
+// encode serialises r as a length-prefixed record: a 4-byte big-endian
+// length followed by that many bytes of payload. In the payload, UserID
+// and Labels are length-prefixed; Fingerprint, Timestamp and Value are
+// fixed 8-byte big-endian fields.
+func (r *Record) encode() []byte {
+	var buf bytes.Buffer
+
+	writeString(&buf, r.UserID)
+	binary.Write(&buf, binary.BigEndian, uint64(r.Fingerprint))
+
+	labels := encodeLabels(r.Labels)
+	writeBytes(&buf, labels)
+
+	binary.Write(&buf, binary.BigEndian, int64(r.Timestamp))
+	binary.Write(&buf, binary.BigEndian, float64(r.Value))
+
+	payload := buf.Bytes()
+	out := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(out, uint32(len(payload)))
+	copy(out[4:], payload)
+	return out
+}
+
+// readRecord reads one length-prefixed Record from r, or returns io.EOF if
+// r is exhausted at a record boundary.
+func readRecord(r io.Reader) (*Record, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("wal: truncated record: %v", err)
+	}
+	buf := bytes.NewReader(payload)
+
+	rec := &Record{}
+	userID, err := readString(buf)
+	if err != nil {
+		return nil, err
+	}
+	rec.UserID = userID
+
+	var fp uint64
+	if err := binary.Read(buf, binary.BigEndian, &fp); err != nil {
+		return nil, err
+	}
+	rec.Fingerprint = model.Fingerprint(fp)
+
+	labelBytes, err := readBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(labelBytes) > 0 {
+		labels, err := decodeLabels(labelBytes)
+		if err != nil {
+			return nil, err
+		}
+		rec.Labels = labels
+	}
+
+	var ts int64
+	if err := binary.Read(buf, binary.BigEndian, &ts); err != nil {
+		return nil, err
+	}
+	rec.Timestamp = model.Time(ts)
+
+	var value float64
+	if err := binary.Read(buf, binary.BigEndian, &value); err != nil {
+		return nil, err
+	}
+	rec.Value = model.SampleValue(value)
+
+	return rec, nil
+}
+
+func encodeLabels(m model.Metric) []byte {
+	if len(m) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(m)))
+	for name, value := range m {
+		writeString(&buf, string(name))
+		writeString(&buf, string(value))
+	}
+	return buf.Bytes()
+}
+
+func decodeLabels(b []byte) (model.Metric, error) {
+	buf := bytes.NewReader(b)
+	var n uint32
+	if err := binary.Read(buf, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	m := make(model.Metric, n)
+	for i := uint32(0); i < n; i++ {
+		name, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+		m[model.LabelName(name)] = model.LabelValue(value)
+	}
+	return m, nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeBytes(buf, []byte(s))
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
+
+func readString(r io.Reader) (string, error) {
+	b, err := readBytes(r)
+	return string(b), err
+}
+
+func readBytes(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	b := make([]byte, length)
+	if length > 0 {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
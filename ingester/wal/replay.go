@@ -0,0 +1,52 @@
+package wal
+
+import (
+	"io"
+	"os"
+
+	"github.com/prometheus/common/model"
+)
+
+// Replay reads every segment in dir, in order, calling apply for each
+// Record whose Timestamp is at or after checkpointed - records before that
+// were already folded into the last successful Checkpoint and can be
+// skipped. The ingester stays in the ring's Joining state for the
+// duration of a Replay, since it isn't safe to route writes to a series
+// set that's still being reconstructed.
+func Replay(dir string, checkpointed model.Time, apply func(*Record)) error {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range segments {
+		if err := replaySegment(segmentPath(dir, n), checkpointed, apply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replaySegment(path string, checkpointed model.Time, apply func(*Record)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for {
+		rec, err := readRecord(f)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// A partial trailing record means the ingester crashed
+			// mid-write; everything up to it is still valid.
+			return nil
+		}
+		if rec.Timestamp < checkpointed {
+			continue
+		}
+		apply(rec)
+	}
+}
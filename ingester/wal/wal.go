@@ -0,0 +1,204 @@
+// Package wal implements a write-ahead log for the ingester: every sample
+// accepted by Push is appended here before it's acknowledged, so an
+// ingester that crashes before its next flush can replay the log on
+// startup and reconstruct its in-memory series instead of losing them.
+package wal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultSegmentSize is the size a segment is rotated at if Config doesn't
+// override it.
+const DefaultSegmentSize = 256 * 1024 * 1024
+
+// Config configures a WAL.
+type Config struct {
+	Dir         string
+	SegmentSize int64 // bytes; defaults to DefaultSegmentSize
+
+	// Log fsyncs once FlushEvery writes have accumulated or FlushInterval
+	// has elapsed since the last fsync, whichever comes first. Both are
+	// checked only inside Log; there is no background fsync.
+	FlushEvery    int
+	FlushInterval time.Duration
+}
+
+func (cfg Config) segmentSize() int64 {
+	if cfg.SegmentSize > 0 {
+		return cfg.SegmentSize
+	}
+	return DefaultSegmentSize
+}
+
+// WAL is an append-only, segmented log of Records.
+type WAL struct {
+	cfg Config
+
+	mtx         sync.Mutex
+	cur         *os.File
+	curSize     int64
+	curSegment  int
+	pending     int
+	lastFlushed time.Time
+}
+
+// Open opens (creating if necessary) the WAL in cfg.Dir, appending to the
+// latest existing segment.
+func Open(cfg Config) (*WAL, error) {
+	if err := os.MkdirAll(cfg.Dir, 0777); err != nil {
+		return nil, err
+	}
+
+	segments, err := listSegments(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAL{cfg: cfg, lastFlushed: time.Now()}
+	next := 0
+	if len(segments) > 0 {
+		next = segments[len(segments)-1]
+	}
+	if err := w.openSegment(next); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Log appends rec to the WAL, rotating to a new segment first if the
+// current one has reached its size limit.
+func (w *WAL) Log(rec *Record) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	buf := rec.encode()
+	if w.curSize+int64(len(buf)) > w.cfg.segmentSize() {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.cur.Write(buf)
+	if err != nil {
+		return err
+	}
+	w.curSize += int64(n)
+	w.pending++
+
+	if w.pending >= w.flushEvery() || time.Since(w.lastFlushed) >= w.flushInterval() {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *WAL) flushEvery() int {
+	if w.cfg.FlushEvery > 0 {
+		return w.cfg.FlushEvery
+	}
+	return 100
+}
+
+func (w *WAL) flushInterval() time.Duration {
+	if w.cfg.FlushInterval > 0 {
+		return w.cfg.FlushInterval
+	}
+	return time.Second
+}
+
+func (w *WAL) flush() error {
+	if err := w.cur.Sync(); err != nil {
+		return err
+	}
+	w.pending = 0
+	w.lastFlushed = time.Now()
+	return nil
+}
+
+func (w *WAL) rotate() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if err := w.cur.Close(); err != nil {
+		return err
+	}
+	return w.openSegment(w.curSegment + 1)
+}
+
+func (w *WAL) openSegment(n int) error {
+	f, err := os.OpenFile(segmentPath(w.cfg.Dir, n), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.cur = f
+	w.curSegment = n
+	w.curSize = stat.Size()
+	return nil
+}
+
+// Close flushes and closes the current segment.
+func (w *WAL) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.cur.Close()
+}
+
+// TruncateBefore removes every segment strictly older than segment n - used
+// after a successful Checkpoint, once its records are no longer needed for
+// replay.
+func (w *WAL) TruncateBefore(n int) error {
+	segments, err := listSegments(w.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s >= n {
+			continue
+		}
+		if err := os.Remove(segmentPath(w.cfg.Dir, s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func segmentPath(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d.wal", n))
+}
+
+func listSegments(dir string) ([]int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	for _, e := range entries {
+		name := e.Name()
+		ext := filepath.Ext(name)
+		if ext != ".wal" {
+			continue
+		}
+		n, err := strconv.Atoi(name[:len(name)-len(ext)])
+		if err != nil {
+			continue
+		}
+		segments = append(segments, n)
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
@@ -0,0 +1,79 @@
+package queryrange
+
+import (
+	"container/list"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// LRUCache is an in-memory, process-local Cache backed by a fixed number
+// of entries. It's meant as the fast first tier in front of a shared
+// memcached Cache, or as a standalone cache for single-process setups.
+type LRUCache struct {
+	mtx      sync.Mutex
+	maxItems int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key string
+	buf []byte
+}
+
+// NewLRUCache makes a new LRUCache holding at most maxItems entries.
+func NewLRUCache(maxItems int) *LRUCache {
+	return &LRUCache{
+		maxItems: maxItems,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// Store implements Cache.
+func (c *LRUCache) Store(ctx context.Context, keys []string, bufs [][]byte) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for i, key := range keys {
+		if elem, ok := c.items[key]; ok {
+			c.ll.MoveToFront(elem)
+			elem.Value.(*lruEntry).buf = bufs[i]
+			continue
+		}
+		elem := c.ll.PushFront(&lruEntry{key: key, buf: bufs[i]})
+		c.items[key] = elem
+		if c.ll.Len() > c.maxItems {
+			c.evictOldest()
+		}
+	}
+	return nil
+}
+
+// Fetch implements Cache.
+func (c *LRUCache) Fetch(ctx context.Context, keys []string) (found []string, bufs [][]byte, missing []string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, key := range keys {
+		elem, ok := c.items[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		c.ll.MoveToFront(elem)
+		found = append(found, key)
+		bufs = append(bufs, elem.Value.(*lruEntry).buf)
+	}
+	return
+}
+
+func (c *LRUCache) evictOldest() {
+	oldest := c.ll.Back()
+	if oldest == nil {
+		return
+	}
+	c.ll.Remove(oldest)
+	delete(c.items, oldest.Value.(*lruEntry).key)
+}
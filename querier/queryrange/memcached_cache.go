@@ -0,0 +1,60 @@
+package queryrange
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// MemcachedConfig configures a MemcachedCache.
+type MemcachedConfig struct {
+	Addresses []string
+	Timeout   time.Duration
+}
+
+// MemcachedCache is a Cache backed by memcached, for sharing cached
+// results across ruler/querier replicas.
+type MemcachedCache struct {
+	client *memcache.Client
+}
+
+// NewMemcachedCache makes a new MemcachedCache.
+func NewMemcachedCache(cfg MemcachedConfig) *MemcachedCache {
+	client := memcache.New(cfg.Addresses...)
+	if cfg.Timeout > 0 {
+		client.Timeout = cfg.Timeout
+	}
+	return &MemcachedCache{client: client}
+}
+
+// Store implements Cache.
+func (c *MemcachedCache) Store(ctx context.Context, keys []string, bufs [][]byte) error {
+	for i, key := range keys {
+		item := &memcache.Item{Key: key, Value: bufs[i]}
+		if err := c.client.Set(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch implements Cache.
+func (c *MemcachedCache) Fetch(ctx context.Context, keys []string) (found []string, bufs [][]byte, missing []string) {
+	items, err := c.client.GetMulti(keys)
+	if err != nil {
+		return nil, nil, keys
+	}
+
+	for _, key := range keys {
+		item, ok := items[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		found = append(found, key)
+		bufs = append(bufs, item.Value)
+	}
+	return
+}
@@ -0,0 +1,64 @@
+// Package queryrange implements a chain of middlewares that sit in front
+// of a range query executor, the way Cortex's range-query result caching
+// does: split a wide [from, to] query into day-aligned sub-queries, serve
+// what's cached, and only execute what's missing.
+package queryrange
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/common/model"
+)
+
+// Request is a range query: a PromQL expression evaluated at Step
+// intervals between Start and End.
+type Request struct {
+	Start, End, Step model.Time
+	Query            string
+}
+
+// Response is the result of evaluating a Request.
+type Response struct {
+	Data model.Matrix
+}
+
+// Handler executes a range query. The terminal Handler in a middleware
+// chain actually runs the query against the querier; everything in front
+// of it only needs to decide what still has to reach that terminal
+// Handler.
+type Handler interface {
+	Do(ctx context.Context, req *Request) (*Response, error)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(ctx context.Context, req *Request) (*Response, error)
+
+// Do implements Handler.
+func (f HandlerFunc) Do(ctx context.Context, req *Request) (*Response, error) {
+	return f(ctx, req)
+}
+
+// Middleware wraps a Handler with another. Middlewares are composed
+// outside-in: the first Middleware in a chain sees the request first.
+type Middleware interface {
+	Wrap(next Handler) Handler
+}
+
+// MiddlewareFunc adapts a function to a Middleware.
+type MiddlewareFunc func(next Handler) Handler
+
+// Wrap implements Middleware.
+func (f MiddlewareFunc) Wrap(next Handler) Handler {
+	return f(next)
+}
+
+// MergeMiddlewares chains middlewares together into a single Middleware,
+// applied in the order they're given.
+func MergeMiddlewares(middlewares ...Middleware) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i].Wrap(next)
+		}
+		return next
+	})
+}
@@ -0,0 +1,120 @@
+package queryrange
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/weaveworks/cortex/user"
+	"github.com/weaveworks/cortex/util"
+)
+
+// Cache is a pluggable key/value store for cached Responses. It is
+// batch-oriented (rather than single-key) so a backend like memcached can
+// pipeline the lookups for every sub-query in a request.
+type Cache interface {
+	Store(ctx context.Context, keys []string, bufs [][]byte) error
+	Fetch(ctx context.Context, keys []string) (found []string, bufs [][]byte, missing []string)
+}
+
+// ResultsCacheConfig configures ResultsCacheMiddleware.
+type ResultsCacheConfig struct {
+	Cache Cache
+	// MaxFreshness bounds how recent a sub-query's End can be and still be
+	// cached - caching a sub-query whose window isn't over yet risks
+	// poisoning the cache with an incomplete result.
+	MaxFreshness time.Duration
+}
+
+// ResultsCacheMiddleware splits a Request into UTC-day-aligned sub-requests
+// (via splitByDay) and caches each sub-request's Response. Splitting and caching live in one
+// middleware, not two, so the whole split's cache lookup is one batched
+// Fetch and everything it comes back missing is one batched Store,
+// instead of a Cache round trip per sub-query.
+func ResultsCacheMiddleware(cfg ResultsCacheConfig) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return &resultsCache{cfg: cfg, next: next}
+	})
+}
+
+type resultsCache struct {
+	cfg  ResultsCacheConfig
+	next Handler
+}
+
+func (r *resultsCache) Do(ctx context.Context, req *Request) (*Response, error) {
+	userID, err := user.ExtractID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subReqs := splitByDay(req)
+	keys := make([]string, len(subReqs))
+	for i, sr := range subReqs {
+		keys[i] = cacheKey(userID, sr)
+	}
+
+	found, bufs, _ := r.cfg.Cache.Fetch(ctx, keys)
+	cached := make(map[string]model.Matrix, len(found))
+	for i, k := range found {
+		var resp Response
+		if err := json.Unmarshal(bufs[i], &resp); err == nil {
+			cached[k] = resp.Data
+		}
+	}
+
+	matrices := make([]model.Matrix, len(subReqs))
+	var storeKeys []string
+	var storeBufs [][]byte
+	for i, sr := range subReqs {
+		if data, ok := cached[keys[i]]; ok {
+			matrices[i] = data
+			continue
+		}
+
+		resp, err := r.next.Do(ctx, sr)
+		if err != nil {
+			return nil, err
+		}
+		matrices[i] = resp.Data
+
+		if r.cacheable(sr) {
+			if buf, err := json.Marshal(resp); err == nil {
+				storeKeys = append(storeKeys, keys[i])
+				storeBufs = append(storeBufs, buf)
+			}
+		}
+	}
+
+	if len(storeKeys) > 0 {
+		if err := r.cfg.Cache.Store(ctx, storeKeys, storeBufs); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Response{Data: util.MergeMatrices(matrices...)}, nil
+}
+
+// cacheable reports whether req's result is safe to cache: its End must be
+// far enough in the past that the samples in it can no longer change.
+func (r *resultsCache) cacheable(req *Request) bool {
+	maxFreshness := r.cfg.MaxFreshness
+	if maxFreshness == 0 {
+		maxFreshness = time.Minute
+	}
+	return time.Since(req.End.Time()) > maxFreshness
+}
+
+// cacheKey builds a key that is unique per tenant, query and aligned step,
+// so tenants (and differently-stepped queries) never collide in a shared
+// cache.
+func cacheKey(userID string, req *Request) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s:%s:%d:%d:%d", userID, req.Query, req.Start, req.End, req.Step)
+	return fmt.Sprintf("%s/%x", userID, h.Sum64())
+}
@@ -0,0 +1,117 @@
+package queryrange
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/weaveworks/cortex/user"
+)
+
+// fakeCache is an in-memory Cache that also counts how many keys each
+// Fetch/Store call was asked to handle at once, so tests can assert the
+// middleware batches rather than calling it once per sub-query.
+type fakeCache struct {
+	data    map[string][]byte
+	fetches []int
+	stores  []int
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{data: map[string][]byte{}}
+}
+
+func (c *fakeCache) Fetch(ctx context.Context, keys []string) ([]string, [][]byte, []string) {
+	c.fetches = append(c.fetches, len(keys))
+	var found, missing []string
+	var bufs [][]byte
+	for _, k := range keys {
+		if buf, ok := c.data[k]; ok {
+			found = append(found, k)
+			bufs = append(bufs, buf)
+		} else {
+			missing = append(missing, k)
+		}
+	}
+	return found, bufs, missing
+}
+
+func (c *fakeCache) Store(ctx context.Context, keys []string, bufs [][]byte) error {
+	c.stores = append(c.stores, len(keys))
+	for i, k := range keys {
+		c.data[k] = bufs[i]
+	}
+	return nil
+}
+
+func TestResultsCacheBatchesFetchAndStore(t *testing.T) {
+	cache := newFakeCache()
+	calls := 0
+	next := HandlerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+		calls++
+		return &Response{Data: model.Matrix{}}, nil
+	})
+
+	mw := ResultsCacheMiddleware(ResultsCacheConfig{Cache: cache})
+	handler := mw.Wrap(next)
+
+	ctx := user.WithID(context.Background(), "user1")
+	// Three UTC days, all old enough to be cacheable.
+	req := &Request{
+		Start: 0,
+		End:   model.Time(3 * millisecondsInDay),
+		Step:  60000,
+		Query: "up",
+	}
+
+	if _, err := handler.Do(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 sub-queries executed, got %d", calls)
+	}
+	if len(cache.fetches) != 1 || cache.fetches[0] != 3 {
+		t.Fatalf("expected a single batched Fetch for 3 keys, got %v", cache.fetches)
+	}
+	if len(cache.stores) != 1 || cache.stores[0] != 3 {
+		t.Fatalf("expected a single batched Store for 3 keys, got %v", cache.stores)
+	}
+
+	// Second call: everything should now be served from cache.
+	calls = 0
+	if _, err := handler.Do(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected every sub-query to be served from cache, executed %d", calls)
+	}
+	if len(cache.fetches) != 2 || cache.fetches[1] != 3 {
+		t.Fatalf("expected a second batched Fetch for 3 keys, got %v", cache.fetches)
+	}
+}
+
+func TestResultsCacheSkipsUnfreshSubRequests(t *testing.T) {
+	cache := newFakeCache()
+	next := HandlerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+		return &Response{Data: model.Matrix{}}, nil
+	})
+
+	mw := ResultsCacheMiddleware(ResultsCacheConfig{Cache: cache})
+	handler := mw.Wrap(next)
+
+	ctx := user.WithID(context.Background(), "user1")
+	req := &Request{
+		Start: model.Now() - model.Time(1000),
+		End:   model.Now(),
+		Step:  60000,
+		Query: "up",
+	}
+	if _, err := handler.Do(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.stores) != 0 {
+		t.Fatalf("expected a too-fresh sub-request not to be stored, got %v", cache.stores)
+	}
+}
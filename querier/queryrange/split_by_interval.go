@@ -0,0 +1,36 @@
+package queryrange
+
+import (
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const millisecondsInDay = int64(24 * time.Hour / time.Millisecond)
+
+// splitByDay breaks req into a sequence of sub-requests, each covering no
+// more than one UTC day, preserving req.Step.
+func splitByDay(req *Request) []*Request {
+	var reqs []*Request
+	for start := req.Start; start < req.End; {
+		end := dayBoundary(start)
+		if end > req.End {
+			end = req.End
+		}
+		reqs = append(reqs, &Request{
+			Start: start,
+			End:   end,
+			Step:  req.Step,
+			Query: req.Query,
+		})
+		start = end
+	}
+	return reqs
+}
+
+// dayBoundary returns the first millisecond timestamp strictly after t that
+// falls on a UTC day boundary.
+func dayBoundary(t model.Time) model.Time {
+	ms := int64(t)
+	return model.Time(((ms / millisecondsInDay) + 1) * millisecondsInDay)
+}
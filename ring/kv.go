@@ -0,0 +1,21 @@
+package ring
+
+// KVClient is a high-level client for key-value stores (Consul, etcd, ...)
+// that exposes only the operations the ring needs: an optimistic
+// read-modify-write and a way to watch a key for changes. Callers pass a
+// factory so the client knows how to decode the raw bytes it gets back
+// from the store.
+type KVClient interface {
+	// CAS atomically modifies the value at key. It calls f with the
+	// current value (decoded via the client's factory, or nil if the key
+	// doesn't exist yet) and writes back whatever f returns, retrying on
+	// concurrent writes until f returns retry=false or an error.
+	CAS(key string, f func(in interface{}) (out interface{}, retry bool, err error)) error
+
+	// Get returns the current value at key, or nil if it doesn't exist.
+	Get(key string) (interface{}, error)
+
+	// WatchKey calls f every time key changes, until f returns false or
+	// done is closed.
+	WatchKey(key string, done <-chan struct{}, f func(interface{}) bool)
+}
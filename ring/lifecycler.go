@@ -0,0 +1,214 @@
+package ring
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// LifecyclerConfig is the config to build a Lifecycler.
+type LifecyclerConfig struct {
+	KVClient KVClient
+	RingKey  string // eg. "ring/ingester" or "ring/ruler"
+
+	ID           string // unique identity of this member within the ring
+	GRPCHostname string
+
+	NumTokens        int
+	HeartbeatPeriod  time.Duration
+	HeartbeatTimeout time.Duration
+	// ClaimTimeout is how long this member stays Pending - observably, in
+	// the ring, with the tokens initRing added - before moving to Joining.
+	// Defaults to DefaultClaimTimeout.
+	ClaimTimeout time.Duration
+	JoinAfter    time.Duration // time to stay in Joining before becoming Active
+
+	// ManualTransitions disables the ClaimTimeout/JoinAfter timers: loop
+	// only heartbeats and sweeps for unhealthy peers, and the owner is
+	// responsible for calling ChangeState itself. The ingester uses this:
+	// it can't promote itself to Active until WAL replay has actually
+	// finished, which a timer can't know about.
+	ManualTransitions bool
+}
+
+// DefaultClaimTimeout is used if LifecyclerConfig.ClaimTimeout isn't set.
+const DefaultClaimTimeout = time.Second
+
+func (cfg LifecyclerConfig) claimTimeout() time.Duration {
+	if cfg.ClaimTimeout > 0 {
+		return cfg.ClaimTimeout
+	}
+	return DefaultClaimTimeout
+}
+
+// Lifecycler is responsible for a single member's presence in a ring: it
+// claims tokens on startup, heartbeats them, walks them through the
+// pending -> joining -> active state machine, and hands them back on a
+// graceful Shutdown. Any service that shards work across a ring of its own
+// instances (ingesters, and now the ruler) embeds one of these.
+type Lifecycler struct {
+	cfg LifecyclerConfig
+
+	done chan struct{}
+	quit chan struct{}
+}
+
+// NewLifecycler makes and starts a new Lifecycler.
+func NewLifecycler(cfg LifecyclerConfig) (*Lifecycler, error) {
+	if cfg.ID == "" {
+		return nil, fmt.Errorf("ring: lifecycler requires a non-empty ID")
+	}
+
+	l := &Lifecycler{
+		cfg:  cfg,
+		done: make(chan struct{}),
+		quit: make(chan struct{}),
+	}
+
+	if err := l.initRing(); err != nil {
+		return nil, err
+	}
+
+	go l.loop()
+	return l, nil
+}
+
+// initRing adds this member to the ring as Pending, if it isn't there
+// already. It deliberately does nothing more than that: Pending needs to
+// be a state other lifecyclers and readers can actually observe, so the
+// claim to Joining happens later, in loop, as its own CAS once
+// ClaimTimeout has elapsed - not folded into this one.
+func (l *Lifecycler) initRing() error {
+	return l.cfg.KVClient.CAS(l.cfg.RingKey, func(in interface{}) (interface{}, bool, error) {
+		desc, ok := in.(*Desc)
+		if !ok || desc == nil {
+			desc = newDesc()
+		}
+		if _, exists := desc.Ingesters[l.cfg.ID]; !exists {
+			tokens := make([]uint32, l.cfg.NumTokens)
+			for i := range tokens {
+				tokens[i] = rand.Uint32()
+			}
+			desc.addIngester(l.cfg.ID, l.cfg.ID, l.cfg.GRPCHostname, tokens, Pending)
+		}
+		return desc, true, nil
+	})
+}
+
+// loop heartbeats this member's entry and, unless ManualTransitions is
+// set, moves it from Pending to Joining once ClaimTimeout has
+// elapsed and promotes it (Joining -> Active) once JoinAfter has elapsed
+// after that. The promote timer is only started once claim actually
+// fires, so a slow or delayed claim can never let promote fire while this
+// member is still Pending and be silently dropped by setState.
+//
+// Every heartbeat also sweeps the whole ring for other members whose
+// heartbeat has timed out and marks them Unhealthy, and revives this
+// member back to Active if it was marked Unhealthy itself and is now
+// heartbeating again. There is no separate ring-watcher service in this
+// tree, so each live lifecycler does its share of that housekeeping.
+func (l *Lifecycler) loop() {
+	defer close(l.done)
+
+	heartbeat := time.NewTicker(l.cfg.HeartbeatPeriod)
+	defer heartbeat.Stop()
+
+	var claimC <-chan time.Time
+	if !l.cfg.ManualTransitions {
+		claim := time.NewTimer(l.cfg.claimTimeout())
+		defer claim.Stop()
+		claimC = claim.C
+	}
+
+	var promote *time.Timer
+	defer func() {
+		if promote != nil {
+			promote.Stop()
+		}
+	}()
+
+	for {
+		var promoteC <-chan time.Time
+		if promote != nil {
+			promoteC = promote.C
+		}
+
+		select {
+		case <-heartbeat.C:
+			if err := l.cfg.KVClient.CAS(l.cfg.RingKey, func(in interface{}) (interface{}, bool, error) {
+				desc, ok := in.(*Desc)
+				if !ok || desc == nil {
+					return nil, false, fmt.Errorf("ring: %s has no Desc at %s", l.cfg.ID, l.cfg.RingKey)
+				}
+
+				desc.heartbeat(l.cfg.ID)
+				if self, ok := desc.Ingesters[l.cfg.ID]; ok && self.State == Unhealthy {
+					desc.setState(l.cfg.ID, Active)
+				}
+
+				now := time.Now()
+				for id, ing := range desc.Ingesters {
+					if id == l.cfg.ID || ing.State == Unhealthy {
+						continue
+					}
+					if now.Sub(ing.Heartbeat) > l.cfg.HeartbeatTimeout {
+						desc.setState(id, Unhealthy)
+					}
+				}
+
+				return desc, true, nil
+			}); err != nil {
+				log.Errorf("Failed to heartbeat to ring: %v", err)
+			}
+
+		case <-claimC:
+			if err := l.ChangeState(Joining); err != nil {
+				log.Errorf("Failed to claim tokens for %s: %v", l.cfg.ID, err)
+			}
+			promote = time.NewTimer(l.cfg.JoinAfter)
+
+		case <-promoteC:
+			if err := l.ChangeState(Active); err != nil {
+				log.Errorf("Failed to mark %s active: %v", l.cfg.ID, err)
+			}
+			promote = nil
+
+		case <-l.quit:
+			return
+		}
+	}
+}
+
+// ChangeState moves this member to newState in the ring.
+func (l *Lifecycler) ChangeState(newState IngesterState) error {
+	return l.cfg.KVClient.CAS(l.cfg.RingKey, func(in interface{}) (interface{}, bool, error) {
+		desc, ok := in.(*Desc)
+		if !ok || desc == nil {
+			return nil, false, fmt.Errorf("ring: %s has no Desc at %s", l.cfg.ID, l.cfg.RingKey)
+		}
+		desc.setState(l.cfg.ID, newState)
+		return desc, true, nil
+	})
+}
+
+// Shutdown marks this member as Leaving and removes it from the ring,
+// handing its tokens back.
+func (l *Lifecycler) Shutdown() error {
+	close(l.quit)
+	<-l.done
+
+	if err := l.ChangeState(Leaving); err != nil {
+		return err
+	}
+
+	return l.cfg.KVClient.CAS(l.cfg.RingKey, func(in interface{}) (interface{}, bool, error) {
+		desc, ok := in.(*Desc)
+		if !ok || desc == nil {
+			return nil, false, nil
+		}
+		desc.removeIngester(l.cfg.ID)
+		return desc, true, nil
+	})
+}
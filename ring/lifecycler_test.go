@@ -0,0 +1,155 @@
+package ring
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKV is an in-memory KVClient good enough to drive a Lifecycler
+// through its state machine in tests, without a real Consul/etcd.
+type fakeKV struct {
+	mtx   sync.Mutex
+	value interface{}
+}
+
+// CAS holds the lock across the whole read-modify-write, so there is never
+// a concurrent write to retry on; retry is ignored.
+func (kv *fakeKV) CAS(key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	kv.mtx.Lock()
+	defer kv.mtx.Unlock()
+
+	out, _, err := f(kv.value)
+	if err != nil {
+		return err
+	}
+	kv.value = out
+	return nil
+}
+
+func (kv *fakeKV) Get(key string) (interface{}, error) {
+	kv.mtx.Lock()
+	defer kv.mtx.Unlock()
+	return kv.value, nil
+}
+
+func (kv *fakeKV) WatchKey(key string, done <-chan struct{}, f func(interface{}) bool) {}
+
+func (kv *fakeKV) state(t *testing.T, id string) IngesterState {
+	t.Helper()
+	kv.mtx.Lock()
+	defer kv.mtx.Unlock()
+	desc, ok := kv.value.(*Desc)
+	if !ok || desc == nil {
+		t.Fatalf("no Desc in KV yet")
+	}
+	ing, ok := desc.Ingesters[id]
+	if !ok {
+		t.Fatalf("no ingester %s in Desc", id)
+	}
+	return ing.State
+}
+
+func waitForState(t *testing.T, kv *fakeKV, id string, want IngesterState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		kv.mtx.Lock()
+		desc, ok := kv.value.(*Desc)
+		kv.mtx.Unlock()
+		if ok && desc != nil {
+			if ing, ok := desc.Ingesters[id]; ok && ing.State == want {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("ingester %s never reached state %v, still %v", id, want, kv.state(t, id))
+}
+
+func TestLifecyclerPendingIsObservableBeforeJoining(t *testing.T) {
+	kv := &fakeKV{}
+	cfg := LifecyclerConfig{
+		KVClient:         kv,
+		RingKey:          "ring/test",
+		ID:               "a",
+		GRPCHostname:     "a",
+		NumTokens:        4,
+		HeartbeatPeriod:  time.Hour,
+		HeartbeatTimeout: time.Hour,
+		ClaimTimeout:     50 * time.Millisecond,
+		JoinAfter:        time.Hour,
+	}
+
+	l, err := NewLifecycler(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	// initRing's CAS has already completed by the time NewLifecycler
+	// returns, and ClaimTimeout hasn't elapsed yet, so Pending must be
+	// the state written and observable right now.
+	if got := kv.state(t, "a"); got != Pending {
+		t.Fatalf("expected Pending immediately after construction, got %v", got)
+	}
+
+	waitForState(t, kv, "a", Joining)
+}
+
+func TestLifecyclerClaimsThenJoins(t *testing.T) {
+	kv := &fakeKV{}
+	cfg := LifecyclerConfig{
+		KVClient:         kv,
+		RingKey:          "ring/test",
+		ID:               "a",
+		GRPCHostname:     "a",
+		NumTokens:        4,
+		HeartbeatPeriod:  time.Hour,
+		HeartbeatTimeout: time.Hour,
+		ClaimTimeout:     10 * time.Millisecond,
+		JoinAfter:        10 * time.Millisecond,
+	}
+
+	l, err := NewLifecycler(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	waitForState(t, kv, "a", Joining)
+	waitForState(t, kv, "a", Active)
+}
+
+func TestLifecyclerMarksStalePeerUnhealthyAndRevivesSelf(t *testing.T) {
+	kv := &fakeKV{}
+
+	desc := newDesc()
+	desc.addIngester("stale", "stale", "stale", []uint32{1}, Active)
+	ing := desc.Ingesters["stale"]
+	ing.Heartbeat = time.Now().Add(-time.Hour)
+	desc.Ingesters["stale"] = ing
+	desc.addIngester("a", "a", "a", []uint32{2}, Unhealthy)
+	kv.value = desc
+
+	cfg := LifecyclerConfig{
+		KVClient:         kv,
+		RingKey:          "ring/test",
+		ID:               "a",
+		GRPCHostname:     "a",
+		NumTokens:        0,
+		HeartbeatPeriod:  10 * time.Millisecond,
+		HeartbeatTimeout: time.Minute,
+		ClaimTimeout:     time.Hour,
+		JoinAfter:        time.Hour,
+	}
+
+	l, err := NewLifecycler(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+
+	waitForState(t, kv, "stale", Unhealthy)
+	waitForState(t, kv, "a", Active)
+}
@@ -10,16 +10,34 @@ type IngesterState int
 
 // Values for IngesterState
 const (
-	Active IngesterState = iota
+	// Pending is the state an ingester starts in. Its tokens are already in
+	// the ring, but it holds no data and serves neither reads nor writes.
+	Pending IngesterState = iota
+	// Joining is the state an ingester is in while it prepares to serve its
+	// tokens (eg. while the ingester replays its WAL). Neither reads nor
+	// writes are routed to it yet.
+	Joining
+	// Active is a healthy ingester that is serving reads and writes.
+	Active
+	// Leaving is an ingester that is gracefully shutting down and handing
+	// off its tokens.
 	Leaving
+	// Unhealthy is an ingester whose heartbeat has timed out.
+	Unhealthy
 )
 
 func (s IngesterState) String() string {
 	switch s {
+	case Pending:
+		return "PENDING"
+	case Joining:
+		return "JOINING"
 	case Active:
-		return "Active"
+		return "ACTIVE"
 	case Leaving:
-		return "Leaving"
+		return "LEAVING"
+	case Unhealthy:
+		return "UNHEALTHY"
 	}
 	return ""
 }
@@ -33,13 +51,40 @@ type Desc struct {
 
 // IngesterDesc describes a single ingester.
 type IngesterDesc struct {
-	Hostname  string        `json:"hostname"`
-	Timestamp time.Time     `json:"timestamp"`
+	Hostname string `json:"hostname"`
+	// Heartbeat is the timestamp of the last heartbeat received from this
+	// ingester. It is used to detect ingesters that have gone Unhealthy.
+	Heartbeat time.Time     `json:"heartbeat"`
 	State     IngesterState `json:"state"`
 
 	GRPCHostname string `json:"grpc_hostname"`
 }
 
+// IsHealthy returns whether the ingester is in a state from which it can
+// serve the given operation (read or write).
+func (i IngesterDesc) IsHealthy(op Operation, heartbeatTimeout time.Duration) bool {
+	if time.Since(i.Heartbeat) > heartbeatTimeout {
+		return false
+	}
+	switch op {
+	case Write:
+		return i.State == Active || i.State == Leaving
+	case Read:
+		return i.State == Active || i.State == Leaving
+	}
+	return false
+}
+
+// Operation distinguishes between the two things the ring is consulted for,
+// since reads and writes tolerate a different set of ingester states.
+type Operation int
+
+// Values for Operation.
+const (
+	Write Operation = iota
+	Read
+)
+
 // TokenDescs is a sortable list of TokenDescs
 type TokenDescs []TokenDesc
 
@@ -67,7 +112,7 @@ func (d *Desc) addIngester(id, hostname, grpcHostname string, tokens []uint32, s
 	d.Ingesters[id] = IngesterDesc{
 		Hostname:     hostname,
 		GRPCHostname: grpcHostname,
-		Timestamp:    time.Now(),
+		Heartbeat:    time.Now(),
 		State:        state,
 	}
 
@@ -81,6 +126,44 @@ func (d *Desc) addIngester(id, hostname, grpcHostname string, tokens []uint32, s
 	sort.Sort(d.Tokens)
 }
 
+// setState transitions the named ingester to newState, ignoring the request
+// if the ingester is not present or the transition isn't one the lifecycler
+// is allowed to make. The allowed transitions are:
+//   pending  -> joining   (lifecycler has claimed its tokens)
+//   joining  -> active    (token handover / WAL replay complete)
+//   active   -> leaving   (graceful shutdown)
+//   anything -> unhealthy (heartbeat timeout)
+//   unhealthy -> active   (heartbeat resumes)
+func (d *Desc) setState(id string, newState IngesterState) {
+	ing, ok := d.Ingesters[id]
+	if !ok {
+		return
+	}
+
+	switch {
+	case ing.State == Pending && newState == Joining:
+	case ing.State == Joining && newState == Active:
+	case ing.State == Active && newState == Leaving:
+	case newState == Unhealthy:
+	case ing.State == Unhealthy && newState == Active:
+	default:
+		return
+	}
+
+	ing.State = newState
+	d.Ingesters[id] = ing
+}
+
+// heartbeat updates the heartbeat timestamp of the named ingester.
+func (d *Desc) heartbeat(id string) {
+	ing, ok := d.Ingesters[id]
+	if !ok {
+		return
+	}
+	ing.Heartbeat = time.Now()
+	d.Ingesters[id] = ing
+}
+
 func (d *Desc) removeIngester(id string) {
 	delete(d.Ingesters, id)
 	output := []TokenDesc{}
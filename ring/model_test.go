@@ -0,0 +1,66 @@
+package ring
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIngesterDescIsHealthy(t *testing.T) {
+	healthy := IngesterDesc{State: Active, Heartbeat: time.Now()}
+	if !healthy.IsHealthy(Write, time.Minute) {
+		t.Fatalf("expected an Active, recently-heartbeating ingester to be healthy for writes")
+	}
+
+	stale := IngesterDesc{State: Active, Heartbeat: time.Now().Add(-time.Hour)}
+	if stale.IsHealthy(Write, time.Minute) {
+		t.Fatalf("expected a stale heartbeat to fail IsHealthy regardless of State")
+	}
+
+	pending := IngesterDesc{State: Pending, Heartbeat: time.Now()}
+	if pending.IsHealthy(Write, time.Minute) {
+		t.Fatalf("expected Pending to be unhealthy for writes")
+	}
+	if pending.IsHealthy(Read, time.Minute) {
+		t.Fatalf("expected Pending to be unhealthy for reads too")
+	}
+
+	leaving := IngesterDesc{State: Leaving, Heartbeat: time.Now()}
+	if !leaving.IsHealthy(Write, time.Minute) {
+		t.Fatalf("expected Leaving to still serve writes (handing off tokens)")
+	}
+	if !leaving.IsHealthy(Read, time.Minute) {
+		t.Fatalf("expected Leaving to still serve reads")
+	}
+}
+
+func TestDescSetStateTransitions(t *testing.T) {
+	d := newDesc()
+	d.addIngester("a", "a", "a", []uint32{1}, Pending)
+
+	cases := []struct {
+		from, attempt, want IngesterState
+	}{
+		{Pending, Joining, Joining},
+		{Joining, Active, Active},
+		{Active, Joining, Active}, // not an allowed transition, ignored
+		{Active, Leaving, Leaving},
+		{Leaving, Unhealthy, Unhealthy},
+		{Unhealthy, Active, Active},
+	}
+
+	for _, c := range cases {
+		d.Ingesters["a"] = IngesterDesc{State: c.from}
+		d.setState("a", c.attempt)
+		if got := d.Ingesters["a"].State; got != c.want {
+			t.Fatalf("from %v, setState(%v): got %v, want %v", c.from, c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestDescSetStateUnknownIngester(t *testing.T) {
+	d := newDesc()
+	d.setState("missing", Active) // must not panic
+	if _, ok := d.Ingesters["missing"]; ok {
+		t.Fatalf("setState must not create an ingester that doesn't exist")
+	}
+}
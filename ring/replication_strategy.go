@@ -0,0 +1,67 @@
+package ring
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrInsufficientReplicas is returned when the ReplicationStrategy cannot
+// build a quorum from the set of replicas handed to it.
+type ErrInsufficientReplicas struct {
+	Available int
+	Needed    int
+	Op        Operation
+}
+
+func (e *ErrInsufficientReplicas) Error() string {
+	return fmt.Sprintf("at least %d live replicas required for %v, could only find %d", e.Needed, e.Op, e.Available)
+}
+
+// ReplicationStrategy decides, for a given operation, which of the N
+// replicas returned by walking the ring's tokens are usable, and whether
+// enough of them are usable to satisfy quorum.
+type ReplicationStrategy struct {
+	// ReplicationFactor is the N tokens were walked for.
+	ReplicationFactor int
+	// MaxUnavailable is how many additional replicas reads are allowed to
+	// consult, beyond ReplicationFactor, when walking past unhealthy
+	// tokens in search of a healthy one.
+	MaxUnavailable int
+	// HeartbeatTimeout is how long since an ingester's last heartbeat
+	// before it is considered Unhealthy.
+	HeartbeatTimeout time.Duration
+}
+
+// Filter takes the ordered list of ingesters a token walk produced and
+// returns the subset usable for op, along with the minimum quorum size
+// required. It returns ErrInsufficientReplicas if quorum cannot be met.
+func (s ReplicationStrategy) Filter(op Operation, ingesters []IngesterDesc) ([]IngesterDesc, int, error) {
+	quorum := s.ReplicationFactor/2 + 1
+
+	maxToTry := s.ReplicationFactor + s.MaxUnavailable
+	if maxToTry > len(ingesters) {
+		maxToTry = len(ingesters)
+	}
+
+	usable := make([]IngesterDesc, 0, s.ReplicationFactor)
+	for i := 0; i < maxToTry; i++ {
+		ing := ingesters[i]
+		if !ing.IsHealthy(op, s.HeartbeatTimeout) {
+			continue
+		}
+		usable = append(usable, ing)
+		// Writes only ever go to ReplicationFactor replicas - the extra
+		// MaxUnavailable ingesters in the walk are there so a read can
+		// still find ReplicationFactor healthy replicas to query, not so
+		// a write over-replicates beyond the configured factor.
+		if op == Write && len(usable) == s.ReplicationFactor {
+			break
+		}
+	}
+
+	if len(usable) < quorum {
+		return nil, quorum, &ErrInsufficientReplicas{Available: len(usable), Needed: quorum, Op: op}
+	}
+
+	return usable, quorum, nil
+}
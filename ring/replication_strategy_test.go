@@ -0,0 +1,120 @@
+package ring
+
+import (
+	"testing"
+	"time"
+)
+
+func healthyIngester(id string) IngesterDesc {
+	return IngesterDesc{Hostname: id, State: Active, Heartbeat: time.Now()}
+}
+
+func TestFilterQuorum(t *testing.T) {
+	strategy := ReplicationStrategy{ReplicationFactor: 3, HeartbeatTimeout: time.Minute}
+
+	ingesters := []IngesterDesc{healthyIngester("a"), healthyIngester("b"), healthyIngester("c")}
+	usable, quorum, err := strategy.Filter(Write, ingesters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quorum != 2 {
+		t.Fatalf("expected quorum 2 for RF 3, got %d", quorum)
+	}
+	if len(usable) != 3 {
+		t.Fatalf("expected all 3 healthy ingesters usable, got %d", len(usable))
+	}
+}
+
+func TestFilterInsufficientReplicas(t *testing.T) {
+	strategy := ReplicationStrategy{ReplicationFactor: 3, HeartbeatTimeout: time.Minute}
+
+	ingesters := []IngesterDesc{
+		healthyIngester("a"),
+		{Hostname: "b", State: Unhealthy, Heartbeat: time.Now()},
+		{Hostname: "c", State: Unhealthy, Heartbeat: time.Now()},
+	}
+	_, _, err := strategy.Filter(Write, ingesters)
+	if err == nil {
+		t.Fatalf("expected ErrInsufficientReplicas when only 1 of 3 is healthy")
+	}
+	if _, ok := err.(*ErrInsufficientReplicas); !ok {
+		t.Fatalf("expected *ErrInsufficientReplicas, got %T", err)
+	}
+}
+
+func TestFilterWriteDoesNotOverReplicate(t *testing.T) {
+	// RF 3 + MaxUnavailable 2 means Get hands Filter up to 5 ingesters so
+	// reads can skip unhealthy ones; a write must still only land on 3.
+	strategy := ReplicationStrategy{ReplicationFactor: 3, MaxUnavailable: 2, HeartbeatTimeout: time.Minute}
+
+	ingesters := []IngesterDesc{
+		healthyIngester("a"), healthyIngester("b"), healthyIngester("c"),
+		healthyIngester("d"), healthyIngester("e"),
+	}
+	usable, _, err := strategy.Filter(Write, ingesters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usable) != 3 {
+		t.Fatalf("expected a write to stop at ReplicationFactor (3) usable ingesters, got %d", len(usable))
+	}
+}
+
+func TestFilterReadExtendsPastUnhealthy(t *testing.T) {
+	strategy := ReplicationStrategy{ReplicationFactor: 2, MaxUnavailable: 1, HeartbeatTimeout: time.Minute}
+
+	ingesters := []IngesterDesc{
+		{Hostname: "a", State: Unhealthy, Heartbeat: time.Now()},
+		healthyIngester("b"),
+		healthyIngester("c"),
+	}
+	usable, quorum, err := strategy.Filter(Read, ingesters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quorum != 2 {
+		t.Fatalf("expected quorum 2, got %d", quorum)
+	}
+	if len(usable) != 2 {
+		t.Fatalf("expected the read to extend past the unhealthy token and find 2 usable ingesters, got %d", len(usable))
+	}
+}
+
+func TestRingGetAndOwns(t *testing.T) {
+	kv := &fakeKV{}
+	desc := newDesc()
+	desc.addIngester("a", "a", "a", []uint32{100}, Active)
+	desc.addIngester("b", "b", "b", []uint32{200}, Active)
+	desc.addIngester("c", "c", "c", []uint32{300}, Active)
+	kv.value = desc
+
+	strategy := ReplicationStrategy{ReplicationFactor: 2, HeartbeatTimeout: time.Minute}
+	r := New(kv, "ring/test", strategy)
+
+	owners, err := r.Get(150, Read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 2 {
+		t.Fatalf("expected 2 owners for RF 2, got %d", len(owners))
+	}
+	if owners[0].Hostname != "b" {
+		t.Fatalf("expected the walk to start at the first token >= key, got %s", owners[0].Hostname)
+	}
+
+	owns, err := r.Owns(150, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !owns {
+		t.Fatalf("expected b to own key 150")
+	}
+
+	owns, err = r.Owns(150, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owns {
+		t.Fatalf("did not expect a to own key 150 under RF 2 starting at token 200")
+	}
+}
@@ -0,0 +1,79 @@
+package ring
+
+import (
+	"sort"
+	"time"
+)
+
+// ReadRing is the read path of a ring: anything that can answer "who owns
+// this key". Both the ingester ring and the ruler ring implement it by
+// wrapping a Ring.
+type ReadRing interface {
+	Get(key uint32, op Operation) ([]IngesterDesc, error)
+}
+
+// Ring holds the KV-backed Desc for a given ring (identified by key, eg.
+// "ring/ingester" or "ring/ruler") and answers ownership queries against
+// it via consistent hashing over the sorted token list.
+type Ring struct {
+	key      string
+	kv       KVClient
+	strategy ReplicationStrategy
+}
+
+// New creates a Ring that reads its Desc from kv under key.
+func New(kv KVClient, key string, strategy ReplicationStrategy) *Ring {
+	return &Ring{
+		key:      key,
+		kv:       kv,
+		strategy: strategy,
+	}
+}
+
+// Get returns the set of ingesters responsible for key, filtered and
+// quorum-checked by the ring's ReplicationStrategy for the given op.
+func (r *Ring) Get(key uint32, op Operation) ([]IngesterDesc, error) {
+	value, err := r.kv.Get(r.key)
+	if err != nil {
+		return nil, err
+	}
+	desc, ok := value.(*Desc)
+	if !ok || desc == nil {
+		desc = newDesc()
+	}
+
+	n := desc.Tokens.Len()
+	start := sort.Search(n, func(i int) bool {
+		return desc.Tokens[i].Token >= key
+	})
+
+	ingesters := make([]IngesterDesc, 0, r.strategy.ReplicationFactor+r.strategy.MaxUnavailable)
+	distinct := map[string]struct{}{}
+	for i := 0; i < n && len(distinct) < r.strategy.ReplicationFactor+r.strategy.MaxUnavailable; i++ {
+		token := desc.Tokens[(start+i)%n]
+		if _, ok := distinct[token.Ingester]; ok {
+			continue
+		}
+		distinct[token.Ingester] = struct{}{}
+		ingesters = append(ingesters, desc.Ingesters[token.Ingester])
+	}
+
+	usable, _, err := r.strategy.Filter(op, ingesters)
+	return usable, err
+}
+
+// Owns reports whether id is the (first) healthy owner of key in the ring,
+// ie. whether a member whose identity is id should act on key. Used by
+// services (like the ruler) that shard deterministic work across their own
+// ring rather than replicating writes.
+func (r *Ring) Owns(key uint32, id string) (bool, error) {
+	owners, err := r.Get(key, Read)
+	if err != nil {
+		return false, err
+	}
+	return len(owners) > 0 && owners[0].Hostname == id, nil
+}
+
+func defaultHeartbeatTimeout() time.Duration {
+	return time.Minute
+}
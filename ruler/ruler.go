@@ -3,12 +3,17 @@ package ruler
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/notifier"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/rules"
 	"golang.org/x/net/context"
@@ -16,10 +21,14 @@ import (
 	"github.com/weaveworks/cortex/chunk"
 	"github.com/weaveworks/cortex/distributor"
 	"github.com/weaveworks/cortex/querier"
+	"github.com/weaveworks/cortex/querier/queryrange"
+	"github.com/weaveworks/cortex/ring"
 	"github.com/weaveworks/cortex/user"
 	"github.com/weaveworks/cortex/util"
 )
 
+const ringKey = "ring/ruler"
+
 // Config is the configuration for the recording rules server.
 type Config struct {
 	DistributorConfig distributor.Config
@@ -27,12 +36,80 @@ type Config struct {
 	ExternalURL       string
 	// How frequently to evaluate rules by default.
 	EvaluationInterval time.Duration
-	// XXX: Currently single tenant only (which is awful) as the most
-	// expedient way of getting *something* working.
-	UserID string
+	// How frequently to poll the configs API for tenants/rule groups and
+	// re-shard against ring membership.
+	PollInterval time.Duration
+
+	// AlertmanagerURL is the default Alertmanager alerting rules notify,
+	// unless a tenant overrides it with alertmanager_url in their config.
+	AlertmanagerURL string
+
+	// ResultsCache configures the split-by-interval result cache that
+	// fronts QueryRange. It has nothing to do with rule evaluation
+	// itself: alerting and recording rules run instant queries against
+	// QueryEngine at eval time, not range queries, so there's no range to
+	// split or cache there. QueryRange is the read path this cache
+	// actually sits in front of.
+	ResultsCache queryrange.ResultsCacheConfig
+
+	RingKVClient     ring.KVClient
+	ListenAddr       string
+	NumTokens        int
+	HeartbeatPeriod  time.Duration
+	HeartbeatTimeout time.Duration
+}
+
+// worker is a per-group evaluator: it owns one rules.Group for a single
+// (tenant, rule group) pair, evaluates it on a ticker until told to stop,
+// and owns the lifecycle of that tenant's notifier.Manager.
+//
+// rulesFile/alertmanagerURL record what the worker was built from, so
+// ensureWorker can tell whether a tenant's config changed underneath it.
+// lastEvaluation/lastError are written from run()'s goroutine and read
+// from ListRules under mtx, since those two run concurrently.
+type worker struct {
+	userID          string
+	groupName       string
+	rulesFile       string
+	alertmanagerURL string
+
+	evaluationInterval time.Duration
+	rulesGroup         *rules.Group
+	notifier           *notifier.Manager
+
+	mtx            sync.Mutex
+	lastEvaluation time.Time
+	lastError      error
+
+	done chan struct{}
 }
 
-// Ruler is a recording rules server.
+func (w *worker) setLastEvaluation(t time.Time, err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.lastEvaluation = t
+	w.lastError = err
+}
+
+// setLastError records a failure that isn't tied to an evaluation, eg. a
+// rule reload that couldn't parse - so it still surfaces on /ruler/rules,
+// without stomping on the last real evaluation time.
+func (w *worker) setLastError(err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.lastError = err
+}
+
+func (w *worker) status() (time.Time, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.lastEvaluation, w.lastError
+}
+
+// Ruler is a ring-sharded, multi-tenant recording and alerting rules
+// server. Each Ruler instance joins a ring of its peers and, for every
+// tenant's rule group, evaluates it if and only if this instance owns
+// hash(userID + groupName) in that ring.
 type Ruler struct {
 	cfg         Config
 	chunkStore  chunk.Store
@@ -40,130 +117,456 @@ type Ruler struct {
 
 	configsAPIURL *url.URL
 	externalURL   *url.URL
-}
 
-// Worker does a thing until it's told to stop.
-type Worker interface {
-	Run()
-	Stop()
+	lifecycler *ring.Lifecycler
+	ring       *ring.Ring
+
+	mtx     sync.Mutex
+	workers map[string]map[string]*worker // userID -> groupName -> worker
+
+	quit chan struct{}
+	done chan struct{}
 }
 
-type worker struct {
-	delay         time.Duration
-	userID        string
-	configsAPIURL *url.URL
-	opts          *rules.ManagerOptions
+// New returns a new Ruler.
+func New(chunkStore chunk.Store, cfg Config) (*Ruler, error) {
+	configsAPIURL, err := url.Parse(cfg.ConfigsAPIURL)
+	if err != nil {
+		return nil, err
+	}
+	externalURL, err := url.Parse(cfg.ExternalURL)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := distributor.New(cfg.DistributorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy := ring.ReplicationStrategy{ReplicationFactor: 1, HeartbeatTimeout: cfg.HeartbeatTimeout}
+	r := ring.New(cfg.RingKVClient, ringKey, strategy)
+
+	lifecycler, err := ring.NewLifecycler(ring.LifecyclerConfig{
+		KVClient:         cfg.RingKVClient,
+		RingKey:          ringKey,
+		ID:               cfg.ListenAddr,
+		GRPCHostname:     cfg.ListenAddr,
+		NumTokens:        cfg.NumTokens,
+		HeartbeatPeriod:  cfg.HeartbeatPeriod,
+		HeartbeatTimeout: cfg.HeartbeatTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ruler := &Ruler{
+		cfg:           cfg,
+		chunkStore:    chunkStore,
+		distributor:   d,
+		configsAPIURL: configsAPIURL,
+		externalURL:   externalURL,
+		lifecycler:    lifecycler,
+		ring:          r,
+		workers:       map[string]map[string]*worker{},
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
 
-	done       chan struct{}
-	terminated chan struct{}
+	go ruler.loop()
+	return ruler, nil
+}
+
+// Stop shuts down the ruler, stopping every group it owns and leaving the
+// ring.
+func (r *Ruler) Stop() {
+	close(r.quit)
+	<-r.done
+	if err := r.lifecycler.Shutdown(); err != nil {
+		log.Errorf("Error leaving ruler ring: %v", err)
+	}
 }
 
-func (w *worker) Run() {
-	defer close(w.terminated)
-	var rs []rules.Rule
-	var group *rules.Group
-	tick := time.NewTicker(w.delay)
+// loop polls the configs API and re-shards owned rule groups against ring
+// membership every PollInterval.
+func (r *Ruler) loop() {
+	defer close(r.done)
+	tick := time.NewTicker(r.cfg.PollInterval)
 	defer tick.Stop()
 	for {
-		var err error
 		select {
-		case <-w.done:
-			return
-		default:
-		}
-		// Select on 'done' again to avoid live-locking.
-		select {
-		case <-w.done:
-			return
 		case <-tick.C:
-			if group == nil {
-				rs, err = w.loadRules()
-				if err != nil {
-					log.Warnf("Could not get configuration for %v: %v", w.userID, err)
-					continue
-				}
-				group = rules.NewGroup("default", w.delay, rs, w.opts)
-			} else {
-				group.Eval()
+			if err := r.sync(); err != nil {
+				log.Warnf("Error syncing rule groups: %v", err)
 			}
+		case <-r.quit:
+			r.stopAll()
+			return
 		}
 	}
 }
 
-func (w *worker) loadRules() ([]rules.Rule, error) {
-	cfg, err := getOrgConfig(w.configsAPIURL, w.userID)
+// sync lists all tenants, recomputes which (tenant, group) pairs this
+// instance owns, starts evaluating newly-owned groups and stops evaluating
+// groups it no longer owns (because they were removed, or ring membership
+// shifted ownership elsewhere).
+func (r *Ruler) sync() error {
+	userIDs, err := listTenants(r.configsAPIURL)
 	if err != nil {
-		return nil, fmt.Errorf("Error fetching config: %v", err)
+		return err
 	}
-	rs, err := loadRules(cfg.RulesFiles)
-	if err != nil {
-		return nil, fmt.Errorf("Error parsing rules: %v", err)
+
+	owned := map[string]map[string]bool{}
+	for _, userID := range userIDs {
+		cfg, err := getOrgConfig(r.configsAPIURL, userID)
+		if err != nil {
+			log.Warnf("Error fetching config for %s: %v", userID, err)
+			continue
+		}
+		for groupName, rulesFile := range cfg.RulesFiles {
+			own, err := r.ring.Owns(tokenFor(userID, groupName), r.cfg.ListenAddr)
+			if err != nil {
+				log.Warnf("Error checking ownership of %s/%s: %v", userID, groupName, err)
+				continue
+			}
+			if !own {
+				continue
+			}
+			if owned[userID] == nil {
+				owned[userID] = map[string]bool{}
+			}
+			owned[userID][groupName] = true
+			r.ensureWorker(userID, groupName, rulesFile, cfg.AlertmanagerURL)
+		}
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for userID, workers := range r.workers {
+		for groupName, w := range workers {
+			if !owned[userID][groupName] {
+				close(w.done)
+				delete(workers, groupName)
+			}
+		}
+		if len(workers) == 0 {
+			delete(r.workers, userID)
+		}
 	}
-	return rs, nil
+	return nil
 }
 
-func (w *worker) Stop() {
-	close(w.done)
-	<-w.terminated
+// tokenFor hashes a (userID, groupName) pair onto the ring's token space.
+func tokenFor(userID, groupName string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	h.Write([]byte{0})
+	h.Write([]byte(groupName))
+	return h.Sum32()
 }
 
-// New returns a new Ruler.
-func New(chunkStore chunk.Store, cfg Config) (*Ruler, error) {
-	configsAPIURL, err := url.Parse(cfg.ConfigsAPIURL)
-	if err != nil {
-		return nil, err
+// ensureWorker starts evaluating (userID, groupName) if it isn't already,
+// and rebuilds the worker if rulesFile or alertmanagerURL has changed
+// since it was built - otherwise a tenant's rule edits or alertmanager_url
+// override would never take effect once the first worker was created.
+func (r *Ruler) ensureWorker(userID, groupName, rulesFile, alertmanagerURL string) {
+	r.mtx.Lock()
+	if r.workers[userID] == nil {
+		r.workers[userID] = map[string]*worker{}
 	}
-	externalURL, err := url.Parse(cfg.ExternalURL)
+	existing, ok := r.workers[userID][groupName]
+	r.mtx.Unlock()
+
+	if ok && existing.rulesFile == rulesFile && existing.alertmanagerURL == alertmanagerURL {
+		return
+	}
+
+	rs, err := loadRules(map[string]string{groupName: rulesFile})
 	if err != nil {
-		return nil, err
+		log.Warnf("Error parsing rules for %s/%s: %v", userID, groupName, err)
+		if ok {
+			existing.setLastError(err)
+		}
+		return
 	}
 
-	d, err := distributor.New(cfg.DistributorConfig)
+	n, err := newNotifier(userID, alertmanagerURL, r.cfg.AlertmanagerURL)
 	if err != nil {
-		return nil, err
+		log.Warnf("Error building notifier for %s: %v", userID, err)
+		if ok {
+			existing.setLastError(err)
+		}
+		return
+	}
+
+	opts := r.getManagerOptions(userID, n)
+	w := &worker{
+		userID:             userID,
+		groupName:          groupName,
+		rulesFile:          rulesFile,
+		alertmanagerURL:    alertmanagerURL,
+		evaluationInterval: r.cfg.EvaluationInterval,
+		rulesGroup:         rules.NewGroup(groupName, r.cfg.EvaluationInterval, rs, opts),
+		notifier:           n,
+		done:               make(chan struct{}),
+	}
+
+	r.mtx.Lock()
+	r.workers[userID][groupName] = w
+	r.mtx.Unlock()
+	go w.run()
+
+	// Stop the superseded worker once its replacement is live, so there's
+	// no gap in evaluation while the new one spins up.
+	if ok {
+		close(existing.done)
 	}
-	return &Ruler{
-		cfg:           cfg,
-		chunkStore:    chunkStore,
-		distributor:   d,
-		configsAPIURL: configsAPIURL,
-		externalURL:   externalURL,
-	}, nil
 }
 
-// GetWorkerFor gets a rules recording worker for the given user.
-// It will keep polling until it can construct one.
-func (r *Ruler) GetWorkerFor(userID string) Worker {
-	delay := time.Duration(r.cfg.EvaluationInterval)
-	return &worker{
-		delay:         delay,
-		userID:        userID,
-		configsAPIURL: r.configsAPIURL,
-		opts:          r.getManagerOptions(userID),
+// run evaluates the group on a ticker and owns the notifier.Manager's
+// lifecycle: it starts it here and stops it, draining its queue, when the
+// worker is told to stop.
+func (w *worker) run() {
+	go w.notifier.Run()
+	defer w.notifier.Stop()
+
+	tick := time.NewTicker(w.evaluationInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			w.rulesGroup.Eval()
+			w.setLastEvaluation(time.Now(), nil)
+		case <-w.done:
+			return
+		}
 	}
 }
 
-func (r *Ruler) getManagerOptions(userID string) *rules.ManagerOptions {
+func (r *Ruler) stopAll() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, workers := range r.workers {
+		for _, w := range workers {
+			close(w.done)
+		}
+	}
+	r.workers = map[string]map[string]*worker{}
+}
+
+// getManagerOptions builds a *rules.ManagerOptions for userID. It is built
+// fresh per tenant, on demand, rather than once at construction, since the
+// ruler now evaluates many tenants concurrently rather than one.
+func (r *Ruler) getManagerOptions(userID string, n *notifier.Manager) *rules.ManagerOptions {
 	ctx := user.WithID(context.Background(), userID)
 	appender := appenderAdapter{distributor: r.distributor, ctx: ctx}
 	queryable := querier.NewQueryable(r.distributor, r.chunkStore)
 	engine := promql.NewEngine(queryable, nil)
 	return &rules.ManagerOptions{
 		SampleAppender: appender,
-		Notifier:       nil,
+		Notifier:       n,
 		QueryEngine:    engine,
 		Context:        ctx,
 		ExternalURL:    r.externalURL,
 	}
 }
 
+// orgIDRoundTripper injects X-Scope-OrgID into every outgoing request, so
+// that alerts a tenant's rules fire land on an Alertmanager that enforces
+// the same per-tenant isolation as the rest of Cortex.
+type orgIDRoundTripper struct {
+	orgID string
+	next  http.RoundTripper
+}
+
+func (t orgIDRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Scope-OrgID", t.orgID)
+	return t.next.RoundTrip(req)
+}
+
+// newNotifier builds a per-tenant notifier.Manager pointed at amURL, or at
+// defaultAMURL if the tenant hasn't overridden it.
+func newNotifier(userID, amURL, defaultAMURL string) (*notifier.Manager, error) {
+	if amURL == "" {
+		amURL = defaultAMURL
+	}
+	parsed, err := url.Parse(amURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alertmanager_url for %s: %v", userID, err)
+	}
+
+	// Built once here rather than reassigned on every Do call: client is
+	// shared across concurrent notifier.Manager.Do calls, so writing its
+	// Transport from inside the hot path would race. setTransport makes
+	// the one write happen-before every read of it.
+	rt := orgIDRoundTripper{orgID: userID, next: http.DefaultTransport}
+	var setTransport sync.Once
+
+	n := notifier.New(&notifier.Options{
+		QueueCapacity: notifier.DefaultOptions.QueueCapacity,
+		Do: func(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+			setTransport.Do(func() { client.Transport = rt })
+			return client.Do(req.WithContext(ctx))
+		},
+	})
+
+	amConfig := &config.AlertmanagerConfig{
+		Scheme: parsed.Scheme,
+		ServiceDiscoveryConfig: config.ServiceDiscoveryConfig{
+			StaticConfigs: []*config.TargetGroup{
+				{Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(parsed.Host)}}},
+			},
+		},
+	}
+	if err := n.ApplyConfig(&config.Config{
+		AlertingConfig: config.AlertingConfig{AlertmanagerConfigs: []*config.AlertmanagerConfig{amConfig}},
+	}); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// RuleGroupStatus is the JSON representation of an owned rule group
+// returned by the /ruler/rules endpoint.
+type RuleGroupStatus struct {
+	UserID         string    `json:"user_id"`
+	GroupName      string    `json:"group_name"`
+	LastEvaluation time.Time `json:"last_evaluation,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+}
+
+// ListRules implements the /ruler/rules HTTP endpoint, exposing the rule
+// groups this instance currently owns and their last evaluation state.
+func (r *Ruler) ListRules(rw http.ResponseWriter, req *http.Request) {
+	type owned struct {
+		userID, groupName string
+		w                 *worker
+	}
+
+	r.mtx.Lock()
+	var all []owned
+	for userID, byGroup := range r.workers {
+		for groupName, w := range byGroup {
+			all = append(all, owned{userID, groupName, w})
+		}
+	}
+	r.mtx.Unlock()
+
+	statuses := make([]RuleGroupStatus, 0, len(all))
+	for _, o := range all {
+		lastEvaluation, lastError := o.w.status()
+		s := RuleGroupStatus{UserID: o.userID, GroupName: o.groupName, LastEvaluation: lastEvaluation}
+		if lastError != nil {
+			s.LastError = lastError.Error()
+		}
+		statuses = append(statuses, s)
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(statuses); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// queryRangeHandler builds the queryrange chain this Ruler serves
+// QueryRange requests through: a terminal Handler that runs the query
+// against the same distributor/chunkStore path rule evaluation uses,
+// fronted by the split-by-interval result cache if one is configured.
+func (r *Ruler) queryRangeHandler() queryrange.Handler {
+	terminal := queryrange.HandlerFunc(func(ctx context.Context, req *queryrange.Request) (*queryrange.Response, error) {
+		queryable := querier.NewQueryable(r.distributor, r.chunkStore)
+		engine := promql.NewEngine(queryable, nil)
+
+		step := time.Duration(req.Step) * time.Millisecond
+		if step <= 0 {
+			step = time.Minute
+		}
+		q, err := engine.NewRangeQuery(req.Query, req.Start.Time(), req.End.Time(), step)
+		if err != nil {
+			return nil, err
+		}
+		result := q.Exec(ctx)
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		matrix, err := result.Matrix()
+		if err != nil {
+			return nil, err
+		}
+		return &queryrange.Response{Data: matrix}, nil
+	})
+
+	if r.cfg.ResultsCache.Cache == nil {
+		return terminal
+	}
+	return queryrange.ResultsCacheMiddleware(r.cfg.ResultsCache).Wrap(terminal)
+}
+
+// QueryRange implements a /ruler/query_range HTTP endpoint: a range query
+// over the same data rule evaluation reads (split by day and cached when
+// Config.ResultsCache has a Cache),
+// useful for checking what a rule's expression would return without
+// waiting for its next scheduled evaluation.
+func (r *Ruler) QueryRange(rw http.ResponseWriter, req *http.Request) {
+	userID := req.Header.Get("X-Scope-OrgID")
+	if userID == "" {
+		http.Error(rw, "missing X-Scope-OrgID", http.StatusUnauthorized)
+		return
+	}
+
+	q := req.URL.Query()
+	start, err := parseTime(q.Get("start"))
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("invalid start: %v", err), http.StatusBadRequest)
+		return
+	}
+	end, err := parseTime(q.Get("end"))
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("invalid end: %v", err), http.StatusBadRequest)
+		return
+	}
+	step, err := parseTime(q.Get("step"))
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("invalid step: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx := user.WithID(context.Background(), userID)
+	resp, err := r.queryRangeHandler().Do(ctx, &queryrange.Request{
+		Start: start,
+		End:   end,
+		Step:  step,
+		Query: q.Get("query"),
+	})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseTime parses a unix timestamp with optional fractional seconds, the
+// same format Prometheus' own HTTP API accepts for start/end/step.
+func parseTime(s string) (model.Time, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return model.Time(int64(f * 1000)), nil
+}
+
 // loadRules loads rules.
 //
 // Strongly inspired by `loadGroups` in Prometheus.
 func loadRules(files map[string]string) ([]rules.Rule, error) {
 	result := []rules.Rule{}
 	for fn, content := range files {
-		stmts, err := promql.ParseStmts(string(content))
+		stmts, err := promql.ParseStmts(content)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing %s: %s", fn, err)
 		}
@@ -206,6 +609,9 @@ func (a appenderAdapter) NeedsThrottling() bool {
 
 type cortexConfig struct {
 	RulesFiles map[string]string `json:"rules_files"`
+	// AlertmanagerURL overrides the ruler's default Alertmanager for this
+	// tenant's alerting rules.
+	AlertmanagerURL string `json:"alertmanager_url,omitempty"`
 }
 
 // getOrgConfig gets the organization's cortex config from a configs api server.
@@ -232,3 +638,26 @@ func getOrgConfig(configsAPIURL *url.URL, userID string) (*cortexConfig, error)
 	}
 	return &config, nil
 }
+
+// listTenants lists the IDs of every tenant known to the configs API, so
+// the ruler can discover rule groups without being told tenants up front.
+func listTenants(configsAPIURL *url.URL) ([]string, error) {
+	url := fmt.Sprintf("%s/api/configs/org", configsAPIURL.String())
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Invalid response from configs server: %v", res.StatusCode)
+	}
+	var userIDs []string
+	if err := json.NewDecoder(res.Body).Decode(&userIDs); err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
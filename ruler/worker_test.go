@@ -0,0 +1,50 @@
+package ruler
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWorkerStatusIsRaceFree exercises the same concurrent access pattern
+// run() and ListRules() have on a worker: one goroutine repeatedly setting
+// lastEvaluation/lastError, another reading them. Run with -race.
+func TestWorkerStatusIsRaceFree(t *testing.T) {
+	w := &worker{userID: "user1", groupName: "group1", done: make(chan struct{})}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			w.setLastEvaluation(time.Now(), nil)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, _ = w.status()
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestWorkerSetLastErrorPreservesLastEvaluation(t *testing.T) {
+	w := &worker{userID: "user1", groupName: "group1", done: make(chan struct{})}
+
+	now := time.Now()
+	w.setLastEvaluation(now, nil)
+	w.setLastError(fmt.Errorf("reload failed"))
+
+	lastEvaluation, lastError := w.status()
+	if !lastEvaluation.Equal(now) {
+		t.Fatalf("expected setLastError to leave lastEvaluation alone, got %v want %v", lastEvaluation, now)
+	}
+	if lastError == nil || lastError.Error() != "reload failed" {
+		t.Fatalf("expected lastError to be set, got %v", lastError)
+	}
+}
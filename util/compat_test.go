@@ -108,3 +108,44 @@ func TestQueryResponse(t *testing.T) {
 	}
 
 }
+
+func TestMergeMatrices(t *testing.T) {
+	// Two sub-query results covering adjacent, overlapping-at-the-boundary
+	// ranges: [10, 20) and [19, 29). Sample 19 is present in both, with a
+	// different value - the later (second) argument should win.
+	first := buildTestMatrix(1, 10, 10) // timestamps 10..19
+	second := buildTestMatrix(1, 10, 19) // timestamps 19..28
+
+	// Give timestamp 19 a different value in second than in first.
+	second[0].Values[0].Value = 100
+
+	have := MergeMatrices(first, second)
+	if len(have) != 1 {
+		t.Fatalf("expected a single merged series, got %d", len(have))
+	}
+
+	want := append([]model.SamplePair{}, first[0].Values[:len(first[0].Values)-1]...)
+	want = append(want, second[0].Values...)
+	if !reflect.DeepEqual(have[0].Values, want) {
+		t.Fatalf("Bad MergeMatrices result: have %v, want %v", have[0].Values, want)
+	}
+}
+
+func TestMergeMatricesRoundTrip(t *testing.T) {
+	want := buildTestMatrix(5, 20, 0)
+	// Splitting a matrix down the middle and merging it back together
+	// should reproduce the original, the way splitting a range query at
+	// day boundaries and merging the cached results back does.
+	left := model.Matrix{}
+	right := model.Matrix{}
+	for _, ss := range want {
+		mid := len(ss.Values) / 2
+		left = append(left, &model.SampleStream{Metric: ss.Metric, Values: ss.Values[:mid]})
+		right = append(right, &model.SampleStream{Metric: ss.Metric, Values: ss.Values[mid:]})
+	}
+
+	have := MergeMatrices(left, right)
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("Bad MergeMatrices round trip")
+	}
+}
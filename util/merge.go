@@ -0,0 +1,68 @@
+package util
+
+import (
+	"sort"
+
+	"github.com/prometheus/common/model"
+)
+
+// MergeMatrices merges a sequence of model.Matrix results that may cover
+// overlapping time ranges (eg. a cached sub-query's result and a freshly
+// executed one covering the same boundary sample) into a single Matrix,
+// sorted the way model.Matrix expects.
+//
+// Where two inputs contain a sample for the same series at the same
+// timestamp, the value from the later argument wins - callers pass fresher
+// results after staler (eg. cached) ones.
+func MergeMatrices(matrices ...model.Matrix) model.Matrix {
+	series := map[model.Fingerprint]*model.SampleStream{}
+	order := []model.Fingerprint{}
+
+	for _, m := range matrices {
+		for _, ss := range m {
+			fp := ss.Metric.Fingerprint()
+			existing, ok := series[fp]
+			if !ok {
+				copied := &model.SampleStream{
+					Metric: ss.Metric,
+					Values: append([]model.SamplePair{}, ss.Values...),
+				}
+				series[fp] = copied
+				order = append(order, fp)
+				continue
+			}
+			existing.Values = mergeSamplePairs(existing.Values, ss.Values)
+		}
+	}
+
+	result := make(model.Matrix, 0, len(order))
+	for _, fp := range order {
+		result = append(result, series[fp])
+	}
+	sort.Sort(result)
+	return result
+}
+
+// mergeSamplePairs merges two timestamp-sorted sample slices, preferring
+// b's value when both have a sample for the same timestamp.
+func mergeSamplePairs(a, b []model.SamplePair) []model.SamplePair {
+	merged := make([]model.SamplePair, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i].Timestamp < b[j].Timestamp:
+			merged = append(merged, a[i])
+			i++
+		case a[i].Timestamp > b[j].Timestamp:
+			merged = append(merged, b[j])
+			j++
+		default:
+			merged = append(merged, b[j])
+			i++
+			j++
+		}
+	}
+	merged = append(merged, a[i:]...)
+	merged = append(merged, b[j:]...)
+	return merged
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"github.com/prometheus/common/model"
+
+	"github.com/weaveworks/cortex/ingester/wal"
+)
+
+// WALCheckpointDiff reports a discrepancy between what a series' last
+// checkpoint says and what replaying the WAL from scratch reconstructs
+// for it - a debugging aid for chasing WAL/checkpoint bugs, not something
+// the ingester consults on its own replay path.
+type WALCheckpointDiff struct {
+	UserID        string
+	Fingerprint   model.Fingerprint
+	InCheckpoint  bool
+	InWAL         bool
+	CheckpointTS  model.Time
+	CheckpointVal model.SampleValue
+	WALTS         model.Time
+	WALVal        model.SampleValue
+}
+
+// DiffWALCheckpoint replays every segment in dir from the beginning and
+// compares, per series, the last sample it sees against what's recorded
+// in dir's checkpoint. Any series present in only one, or whose last
+// sample disagrees, is reported.
+func DiffWALCheckpoint(dir string) ([]WALCheckpointDiff, error) {
+	checkpoint, err := wal.LoadCheckpoint(dir)
+	if err != nil {
+		return nil, err
+	}
+	checkpointed := make(map[model.Fingerprint]wal.SeriesSnapshot, len(checkpoint))
+	for _, s := range checkpoint {
+		checkpointed[s.Fingerprint] = s
+	}
+
+	reconstructed := map[model.Fingerprint]wal.SeriesSnapshot{}
+	err = wal.Replay(dir, 0, func(rec *wal.Record) {
+		reconstructed[rec.Fingerprint] = wal.SeriesSnapshot{
+			UserID:      rec.UserID,
+			Fingerprint: rec.Fingerprint,
+			Timestamp:   rec.Timestamp,
+			Value:       rec.Value,
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[model.Fingerprint]bool{}
+	var diffs []WALCheckpointDiff
+	for fp, c := range checkpointed {
+		seen[fp] = true
+		w, inWAL := reconstructed[fp]
+		if inWAL && c.Timestamp == w.Timestamp && c.Value == w.Value {
+			continue
+		}
+		diffs = append(diffs, WALCheckpointDiff{
+			UserID:        c.UserID,
+			Fingerprint:   fp,
+			InCheckpoint:  true,
+			InWAL:         inWAL,
+			CheckpointTS:  c.Timestamp,
+			CheckpointVal: c.Value,
+			WALTS:         w.Timestamp,
+			WALVal:        w.Value,
+		})
+	}
+	for fp, w := range reconstructed {
+		if seen[fp] {
+			continue
+		}
+		diffs = append(diffs, WALCheckpointDiff{
+			UserID:      w.UserID,
+			Fingerprint: fp,
+			InWAL:       true,
+			WALTS:       w.Timestamp,
+			WALVal:      w.Value,
+		})
+	}
+	return diffs, nil
+}